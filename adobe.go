@@ -0,0 +1,98 @@
+package jfif
+
+import (
+    "fmt"
+    "bytes"
+    "encoding/binary"
+)
+
+// colour transform of the Adobe segment
+const (
+    AdobeTransformNone = 0 // RGB or CMYK as is
+    AdobeTransformYCbCr = 1
+    AdobeTransformYCCK = 2
+)
+
+// Adobe APP14 segment contents
+type Adobe struct {
+    Version Word // DCTEncodeVersion
+    Flags0, Flags1 Word
+    Transform Byte
+}
+
+func IsAdobe(data []byte) bool {
+    return bytes.HasPrefix(data, []byte(SigAdobe)) && len(data) >= len(SigAdobe) + 7
+}
+
+// parses APP14 payload (with the signature)
+func ParseAdobe(data []byte) (*Adobe, error) {
+    if !IsAdobe(data) {
+        return nil, fmt.Errorf("exif.ParseAdobe: not an Adobe segment")
+    }
+    d := data[len(SigAdobe):]
+    return &Adobe{
+        Version: GetWordBE(d),
+        Flags0: GetWordBE(d[2:]),
+        Flags1: GetWordBE(d[4:]),
+        Transform: Byte(d[6]),
+    }, nil
+}
+
+// the APP14 payload
+func (a *Adobe) Bytes() []byte {
+    data := append([]byte(SigAdobe), 0, 0, 0, 0, 0, 0, byte(a.Transform))
+    binary.BigEndian.PutUint16(data[5:], uint16(a.Version))
+    binary.BigEndian.PutUint16(data[7:], uint16(a.Flags0))
+    binary.BigEndian.PutUint16(data[9:], uint16(a.Flags1))
+    return data
+}
+
+func (x *Jfif) adobeEntry() *AppnEntry {
+    for _, entry := range x.Entries {
+        if app, ok := entry.(*AppnEntry); ok && app.ID == APPe && IsAdobe(app.Data) {
+            return app
+        }
+    }
+    return nil
+}
+
+// parses the Adobe APP14 segment, returns nil if there is none
+func (x *Jfif) Adobe() (*Adobe, error) {
+    app := x.adobeEntry()
+    if app == nil {
+        return nil, nil
+    }
+    a, e := ParseAdobe(app.Data)
+    if e != nil {
+        return nil, fmt.Errorf("exif.Adobe(%q): %w", x.Path, e)
+    }
+    return a, nil
+}
+
+// stores the Adobe APP14 segment, inserting one after the other APPn
+// segments if needed; nil `a` removes it
+func (x *Jfif) SetAdobe(a *Adobe) error {
+    app := x.adobeEntry()
+    if a == nil {
+        if app == nil {
+            return nil
+        }
+        return x.Remove(app)
+    }
+    if app != nil {
+        app.Data = a.Bytes()
+        return nil
+    }
+    at := 0
+    for i, entry := range x.Entries {
+        id := entry.GetId()
+        if id == SOI || (id >= APP0 && id <= APPf) { at = i + 1; }
+        if id != SOI && id != COM && (id < APP0 || id > APPf) { break; }
+    }
+    if e := x.insert(at, &AppnEntry{Xff0: 255, ID: APPe, Data: a.Bytes()}); e != nil {
+        return fmt.Errorf("exif.SetAdobe(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
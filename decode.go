@@ -0,0 +1,453 @@
+package jfif
+
+import (
+    "fmt"
+    "math"
+    "image"
+    "image/color"
+)
+
+// Huffman decoding tables, see JPEG Annex F.2.2.3
+type huffDecoder struct {
+    maxcode [17]int32   // largest code of the length, -1 if none
+    valptr [17]int      // index of the first symbol of the length
+    mincode [17]int32
+    symbols []byte
+}
+
+func newHuffDecoder(ht *HuffmanTable) (*huffDecoder, error) {
+    codes, e := ht.Codes()
+    if e != nil {
+        return nil, e
+    }
+    d := &huffDecoder{symbols: ht.Symbols}
+    k := 0
+    for size := 1; size <= 16; size++ {
+        d.maxcode[size] = -1
+        if n := int(ht.Counts[size-1]); n > 0 {
+            d.valptr[size] = k
+            d.mincode[size] = int32(codes[k].Code)
+            k += n
+            d.maxcode[size] = int32(codes[k-1].Code)
+        }
+    }
+    return d, nil
+}
+
+// entropy-coded data reader, FF 00 is unstuffed and the data is padded
+// with 1 bits past its end (as libjpeg does)
+type bitReader struct {
+    data []byte
+    pos int
+    acc uint32
+    n uint
+}
+
+func (br *bitReader) fill() {
+    for br.n <= 24 {
+        var b byte = 0xff
+        if br.pos < len(br.data) {
+            b = br.data[br.pos]
+            br.pos++
+            if b == 0xff && br.pos < len(br.data) && br.data[br.pos] == 0 {
+                br.pos++
+            }
+        }
+        br.acc |= uint32(b) << (24 - br.n)
+        br.n += 8
+    }
+}
+
+func (br *bitReader) bits(n uint) int32 {
+    if n == 0 { return 0; }
+    if br.n < n { br.fill(); }
+    v := int32(br.acc >> (32 - n))
+    br.acc <<= n
+    br.n -= n
+    return v
+}
+
+func (br *bitReader) decode(d *huffDecoder) (byte, error) {
+    var code int32
+    for size := 1; size <= 16; size++ {
+        code = code << 1 | br.bits(1)
+        if code <= d.maxcode[size] {
+            return d.symbols[d.valptr[size] + int(code - d.mincode[size])], nil
+        }
+    }
+    return 0, fmt.Errorf("bad Huffman code")
+}
+
+// receives `s` bits of a coefficient and extends its sign (JPEG F.2.2.1)
+func (br *bitReader) receive(s byte) int32 {
+    v := br.bits(uint(s))
+    if s > 0 && v < 1 << (s - 1) {
+        v += -1 << s + 1
+    }
+    return v
+}
+
+// quantized DCT coefficients of a component in natural order
+type coefPlane struct {
+    FrameComponent
+    bw, bh int              // blocks per line and per column (MCU-padded)
+    blocks [][64]int32
+    quant [64]Word          // natural order
+}
+
+func (p *coefPlane) block(bx, by int) *[64]int32 {
+    return &p.blocks[by * p.bw + bx]
+}
+
+// quantized DCT coefficients of a frame
+type coefImage struct {
+    frame *SofEntry
+    planes []*coefPlane
+    mcusX, mcusY int
+}
+
+// decodes every scan of a sequential Huffman-coded frame into coefficients;
+// tables are taken as they are defined in between the scans
+func (x *Jfif) decodeCoefficients() (*coefImage, error) {
+    var img *coefImage
+    var dc, ac [4]*huffDecoder
+    quant := make(map[Byte]*QuantTable)
+    var interval Word
+    for _, entry := range x.Entries {
+        switch ent := entry.(type) {
+        case *DqtEntry:
+            for i := range ent.Tables { quant[ent.Tables[i].ID] = &ent.Tables[i]; }
+        case *DhtEntry:
+            for i := range ent.Tables {
+                ht := &ent.Tables[i]
+                d, e := newHuffDecoder(ht)
+                if e != nil { return nil, e; }
+                if ht.Class == 0 { dc[ht.ID] = d; } else { ac[ht.ID] = d; }
+            }
+        case *DriEntry:
+            interval = ent.Interval
+        case *SofEntry:
+            if img != nil { return nil, fmt.Errorf("multiple frames"); }
+            if ent.ID != SOF0 && ent.ID != SOF1 {
+                return nil, fmt.Errorf("unsupported frame type %s", EntryName[ent.ID])
+            }
+            if ent.Precision != 8 {
+                return nil, fmt.Errorf("unsupported precision %d", ent.Precision)
+            }
+            if ent.Width == 0 || ent.Height == 0 || len(ent.Components) == 0 {
+                return nil, fmt.Errorf("empty frame %v", ent)
+            }
+            img = newCoefImage(ent)
+        case *SosEntry:
+            if img == nil { return nil, fmt.Errorf("scan before frame"); }
+            if e := img.decodeScan(ent, &dc, &ac, quant, int(interval)); e != nil {
+                return nil, e
+            }
+        }
+    }
+    if img == nil {
+        return nil, fmt.Errorf("no frame")
+    }
+    return img, nil
+}
+
+func newCoefImage(sof *SofEntry) *coefImage {
+    img := &coefImage{frame: sof}
+    H, V := sof.MaxSampling()
+    if len(sof.Components) == 1 {
+        H, V = 1, 1
+    }
+    img.mcusX = (int(sof.Width) + 8 * int(H) - 1) / (8 * int(H))
+    img.mcusY = (int(sof.Height) + 8 * int(V) - 1) / (8 * int(V))
+    for _, c := range sof.Components {
+        p := &coefPlane{FrameComponent: c}
+        if len(sof.Components) == 1 {
+            p.bw, p.bh = img.mcusX, img.mcusY
+        } else {
+            p.bw, p.bh = img.mcusX * int(c.H), img.mcusY * int(c.V)
+        }
+        p.blocks = make([][64]int32, p.bw * p.bh)
+        img.planes = append(img.planes, p)
+    }
+    return img
+}
+
+// blocks of the component actually covered by the image (not MCU-padded)
+func (img *coefImage) compBlocks(p *coefPlane) (int, int) {
+    H, V := img.frame.MaxSampling()
+    w := (int(img.frame.Width) * int(p.H) + int(H) - 1) / int(H)
+    h := (int(img.frame.Height) * int(p.V) + int(V) - 1) / int(V)
+    return (w + 7) / 8, (h + 7) / 8
+}
+
+// calls `f` for every block of the scan in coding order, `mcu` is
+// the number of the MCU the block belongs to
+func (img *coefImage) scanBlocks(planes []*coefPlane, f func(p *coefPlane, ci, bx, by, mcu int) error) error {
+    if len(planes) == 1 { // non-interleaved: an MCU is a single block
+        p := planes[0]
+        w, h := img.compBlocks(p)
+        for by := 0; by < h; by++ {
+            for bx := 0; bx < w; bx++ {
+                if e := f(p, 0, bx, by, by * w + bx); e != nil { return e; }
+            }
+        }
+        return nil
+    }
+    for my := 0; my < img.mcusY; my++ {
+        for mx := 0; mx < img.mcusX; mx++ {
+            for ci, p := range planes {
+                for v := 0; v < int(p.V); v++ {
+                    for h := 0; h < int(p.H); h++ {
+                        bx, by := mx * int(p.H) + h, my * int(p.V) + v
+                        if e := f(p, ci, bx, by, my * img.mcusX + mx); e != nil { return e; }
+                    }
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// the frame components the scan refers to
+func (img *coefImage) scanPlanes(sos *SosEntry) ([]*coefPlane, error) {
+    var planes []*coefPlane
+    for _, sc := range sos.Components {
+        var found *coefPlane
+        for _, p := range img.planes {
+            if p.ID == sc.Id { found = p; }
+        }
+        if found == nil {
+            return nil, fmt.Errorf("scan refers to unknown component %d", sc.Id)
+        }
+        planes = append(planes, found)
+    }
+    if len(planes) == 0 {
+        return nil, fmt.Errorf("scan without components")
+    }
+    return planes, nil
+}
+
+func (img *coefImage) decodeScan(sos *SosEntry, dc, ac *[4]*huffDecoder, quant map[Byte]*QuantTable, interval int) error {
+    planes, e := img.scanPlanes(sos)
+    if e != nil {
+        return e
+    }
+    ss, se := sos.SpectralSelection()
+    ah, al := sos.SuccessiveApproximation()
+    if ss != 0 || se != 63 || ah != 0 || al != 0 {
+        return fmt.Errorf("not a sequential scan: Ss=%d Se=%d Ah=%d Al=%d", ss, se, ah, al)
+    }
+    dcs := make([]*huffDecoder, len(planes))
+    acs := make([]*huffDecoder, len(planes))
+    for i, p := range planes {
+        qt := quant[p.Tq]
+        if qt == nil {
+            return fmt.Errorf("no quantization table %d", p.Tq)
+        }
+        p.quant = qt.Natural()
+        dcs[i], acs[i] = dc[sos.Components[i].Ht >> 4 & 3], ac[sos.Components[i].Ht & 3]
+        if dcs[i] == nil || acs[i] == nil {
+            return fmt.Errorf("no Huffman table for component %d", p.ID)
+        }
+    }
+
+    intervals := sos.Intervals()
+    n := 0
+    br := &bitReader{data: intervals[0].Data}
+    pred := make([]int32, len(planes))
+    last := 0
+    return img.scanBlocks(planes, func(p *coefPlane, ci, bx, by, mcu int) error {
+        if interval > 0 && mcu != last && mcu % interval == 0 {
+            n++
+            if n >= len(intervals) {
+                return fmt.Errorf("missing restart interval %d", n)
+            }
+            br = &bitReader{data: intervals[n].Data}
+            for i := range pred { pred[i] = 0; }
+        }
+        last = mcu
+        blk := p.block(bx, by)
+        t, e := br.decode(dcs[ci])
+        if e != nil {
+            return fmt.Errorf("component %d block %d,%d: DC %v", p.ID, bx, by, e)
+        }
+        if t > 11 {
+            return fmt.Errorf("component %d block %d,%d: bad coefficient category %d", p.ID, bx, by, t)
+        }
+        pred[ci] += br.receive(t)
+        blk[0] = pred[ci]
+        for k := 1; k < 64; k++ {
+            rs, e := br.decode(acs[ci])
+            if e != nil {
+                return fmt.Errorf("component %d block %d,%d: AC %v", p.ID, bx, by, e)
+            }
+            r, s := int(rs >> 4), rs & 15
+            if s > 10 {
+                return fmt.Errorf("component %d block %d,%d: bad coefficient category %d", p.ID, bx, by, s)
+            }
+            if s == 0 {
+                if r != 15 { break; } // EOB
+                k += 15
+                continue
+            }
+            k += r
+            if k > 63 {
+                return fmt.Errorf("component %d block %d,%d: coefficient overflow", p.ID, bx, by)
+            }
+            blk[zigzag[k]] = br.receive(s)
+        }
+        return nil
+    })
+}
+
+var idctCos [8][8]float64 // [x][u] = C(u) * cos((2x+1)uPI/16) / 2
+
+func init() {
+    for x := 0; x < 8; x++ {
+        for u := 0; u < 8; u++ {
+            c := 1.0
+            if u == 0 { c = 1 / math.Sqrt2; }
+            idctCos[x][u] = c * math.Cos(float64(2 * x + 1) * float64(u) * math.Pi / 16) / 2
+        }
+    }
+}
+
+// dequantizes and transforms the block into level-shifted samples
+func idct(blk *[64]int32, quant *[64]Word, out []byte, stride int) {
+    var tmp [64]float64
+    for v := 0; v < 8; v++ { // rows
+        for x := 0; x < 8; x++ {
+            var sum float64
+            for u := 0; u < 8; u++ {
+                if c := blk[v * 8 + u]; c != 0 {
+                    sum += idctCos[x][u] * float64(c * int32(quant[v * 8 + u]))
+                }
+            }
+            tmp[v * 8 + x] = sum
+        }
+    }
+    for x := 0; x < 8; x++ { // columns
+        for y := 0; y < 8; y++ {
+            var sum float64
+            for v := 0; v < 8; v++ {
+                sum += idctCos[y][v] * tmp[v * 8 + x]
+            }
+            s := math.Round(sum) + 128
+            if s < 0 { s = 0; }
+            if s > 255 { s = 255; }
+            out[y * stride + x] = byte(s)
+        }
+    }
+}
+
+// sample planes of the components (MCU-padded)
+func (img *coefImage) samples() [][]byte {
+    res := make([][]byte, len(img.planes))
+    for i, p := range img.planes {
+        stride := p.bw * 8
+        res[i] = make([]byte, stride * p.bh * 8)
+        for by := 0; by < p.bh; by++ {
+            for bx := 0; bx < p.bw; bx++ {
+                idct(p.block(bx, by), &p.quant, res[i][by * 8 * stride + bx * 8:], stride)
+            }
+        }
+    }
+    return res
+}
+
+// colour transform of the frame: the Adobe segment one (-1 if there is
+// none) unless the component IDs tell RGB, YCbCr for three components,
+// YCCK for four otherwise
+func (img *coefImage) colorTransform(adobe int) int {
+    if adobe >= 0 {
+        return adobe
+    }
+    c := img.frame.Components
+    if len(c) == 3 && c[0].ID == 'R' && c[1].ID == 'G' && c[2].ID == 'B' {
+        return AdobeTransformNone
+    }
+    if len(c) == 4 {
+        return AdobeTransformNone
+    }
+    return AdobeTransformYCbCr
+}
+
+// converts coefficients to pixels: grayscale for a single component, RGBA
+// for three and CMYK for four (upsampled by replication); `adobe` is the
+// Adobe segment colour transform, -1 if there is none; CMYK is expected
+// inverted the way Adobe writes it
+func (img *coefImage) image(adobe int) (image.Image, error) {
+    w, h := int(img.frame.Width), int(img.frame.Height)
+    planes := img.samples()
+    transform := img.colorTransform(adobe)
+    H, V := img.frame.MaxSampling()
+    var c [4]byte
+    sample := func(x, y int) {
+        for i, p := range img.planes {
+            sx := x * int(p.H) / int(H)
+            sy := y * int(p.V) / int(V)
+            c[i] = planes[i][sy * p.bw * 8 + sx]
+        }
+    }
+    switch len(img.planes) {
+    case 1:
+        res := image.NewGray(image.Rect(0, 0, w, h))
+        stride := img.planes[0].bw * 8
+        for y := 0; y < h; y++ {
+            copy(res.Pix[y * res.Stride:y * res.Stride + w], planes[0][y * stride:])
+        }
+        return res, nil
+    case 3:
+        res := image.NewRGBA(image.Rect(0, 0, w, h))
+        for y := 0; y < h; y++ {
+            for x := 0; x < w; x++ {
+                sample(x, y)
+                r, g, b := c[0], c[1], c[2]
+                if transform != AdobeTransformNone {
+                    r, g, b = color.YCbCrToRGB(c[0], c[1], c[2])
+                }
+                o := y * res.Stride + x * 4
+                res.Pix[o], res.Pix[o+1], res.Pix[o+2], res.Pix[o+3] = r, g, b, 255
+            }
+        }
+        return res, nil
+    case 4:
+        res := image.NewCMYK(image.Rect(0, 0, w, h))
+        for y := 0; y < h; y++ {
+            for x := 0; x < w; x++ {
+                sample(x, y)
+                o := y * res.Stride + x * 4
+                if transform == AdobeTransformNone {
+                    res.Pix[o], res.Pix[o+1], res.Pix[o+2] = 255 - c[0], 255 - c[1], 255 - c[2]
+                } else { // YCC of the inverted CMY: its RGB is CMY
+                    res.Pix[o], res.Pix[o+1], res.Pix[o+2] = color.YCbCrToRGB(c[0], c[1], c[2])
+                }
+                res.Pix[o+3] = 255 - c[3]
+            }
+        }
+        return res, nil
+    }
+    return nil, fmt.Errorf("unsupported number of components %d", len(img.planes))
+}
+
+// decodes a baseline (sequential Huffman) image into pixels, the colour
+// transform of the Adobe segment is taken into account
+func (x *Jfif) Decode() (image.Image, error) {
+    adobe, e := x.Adobe()
+    if e != nil {
+        return nil, fmt.Errorf("exif.Decode(%q): %w", x.Path, e)
+    }
+    transform := -1
+    if adobe != nil { transform = int(adobe.Transform); }
+    img, e := x.decodeCoefficients()
+    if e != nil {
+        return nil, fmt.Errorf("exif.Decode(%q): %w", x.Path, e)
+    }
+    res, e := img.image(transform)
+    if e != nil {
+        return nil, fmt.Errorf("exif.Decode(%q): %w", x.Path, e)
+    }
+    return res, nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,211 @@
+package jfif
+
+import (
+    "os"
+    "fmt"
+    "bytes"
+    "image"
+    "strings"
+    "image/color"
+    "image/jpeg"
+)
+import "testing"
+
+// mean absolute difference of RGB channels
+func imageDiff(a, b image.Image) float64 {
+    r := a.Bounds()
+    var sum, n float64
+    for y := r.Min.Y; y < r.Max.Y; y++ {
+        for x := r.Min.X; x < r.Max.X; x++ {
+            r1, g1, b1, _ := a.At(x, y).RGBA()
+            r2, g2, b2, _ := b.At(x, y).RGBA()
+            for _, d := range []int{int(r1 >> 8) - int(r2 >> 8), int(g1 >> 8) - int(g2 >> 8), int(b1 >> 8) - int(b2 >> 8)} {
+                if d < 0 { d = -d; }
+                sum += float64(d)
+            }
+            n += 3
+        }
+    }
+    return sum / n
+}
+
+// a colourful test picture encoded by image/jpeg (4:2:0, two DQT and
+// four DHT tables in a single segment each)
+func testJpeg(t *testing.T, w, h int) []byte {
+    src := image.NewRGBA(image.Rect(0, 0, w, h))
+    for y := 0; y < h; y++ {
+        for x := 0; x < w; x++ {
+            src.Set(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8((x ^ y) * 4), 255})
+        }
+    }
+    var out bytes.Buffer
+    if e := jpeg.Encode(&out, src, &jpeg.Options{Quality: 85}); e != nil {
+        t.Fatalf("jpeg.Encode(): %v", e)
+    }
+    return out.Bytes()
+}
+
+func testDecode(t *testing.T, name string, data []byte) {
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("%s: cannot parse: %v", name, e)
+    }
+    img, e := X.Decode()
+    if e != nil {
+        t.Fatalf("%s: cannot decode: %v", name, e)
+    }
+    ref, e := jpeg.Decode(bytes.NewReader(data))
+    if e != nil {
+        t.Fatalf("%s: jpeg.Decode(): %v", name, e)
+    }
+    if img.Bounds() != ref.Bounds() {
+        t.Fatalf("%s: bounds %v, expected %v", name, img.Bounds(), ref.Bounds())
+    }
+    if d := imageDiff(img, ref); d > 0.5 {
+        t.Errorf("%s: mean difference %.3f", name, d)
+    }
+}
+
+func TestDecode(t *testing.T) {
+    testDecode(t, "generated", testJpeg(t, 77, 45))
+    for _, path := range testImages(t) {
+        data, e := os.ReadFile(path)
+        if e != nil {
+            t.Fatalf("os.ReadFile(%q): %v", path, e)
+        }
+        testDecode(t, path, data)
+    }
+}
+
+func TestDecodeCorruptTable(t *testing.T) {
+    for _, class := range []Byte{0, 1} {
+        var X Jfif
+        if e := X.Parse(testJpeg(t, 77, 45)); e != nil {
+            t.Fatalf("Cannot parse: %v", e)
+        }
+        for _, entry := range X.FindAll(DHT) {
+            for _, ht := range entry.(*DhtEntry).Tables {
+                if ht.Class != class { continue; }
+                for i := range ht.Symbols { ht.Symbols[i] = 0x0f; } // DC 15, AC 0/15
+            }
+        }
+        if _, e := X.Decode(); e == nil || !strings.Contains(e.Error(), "bad coefficient category") {
+            t.Errorf("Class %d: %v", class, e)
+        }
+    }
+}
+
+func TestAdobeTransform(t *testing.T) {
+    var X Jfif
+    if e := X.Parse(testJpeg(t, 77, 45)); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    adobe := &Adobe{Version: 100, Transform: AdobeTransformNone}
+    if e := X.SetAdobe(adobe); e != nil {
+        t.Fatalf("SetAdobe(): %v", e)
+    }
+    got, e := X.Adobe()
+    if e != nil || got == nil || *got != *adobe {
+        t.Fatalf("Adobe(): %+v, %v", got, e)
+    }
+    data, e := X.Bytes()
+    if e != nil {
+        t.Fatalf("Bytes(): %v", e)
+    }
+    testDecode(t, "adobe rgb", data) // image/jpeg honours the transform too
+
+    // a flat 4-component frame: DC only, the samples are DC / 8 + 128
+    sof := &SofEntry{Width: 8, Height: 8}
+    for i := 1; i <= 4; i++ {
+        sof.Components = append(sof.Components, FrameComponent{ID: Byte(i), H: 1, V: 1})
+    }
+    img := newCoefImage(sof)
+    for i, v := range []int32{200, 100, 50, 30} {
+        p := img.planes[i]
+        p.quant[0] = 1
+        p.block(0, 0)[0] = (v - 128) * 8
+    }
+    for _, test := range []struct{ transform int; cmyk color.CMYK }{
+        {-1, color.CMYK{55, 155, 205, 225}},
+        {AdobeTransformNone, color.CMYK{55, 155, 205, 225}},
+    } {
+        res, e := img.image(test.transform)
+        if e != nil {
+            t.Fatalf("image(%d): %v", test.transform, e)
+        }
+        if c := res.(*image.CMYK).CMYKAt(3, 3); c != test.cmyk {
+            t.Errorf("image(%d): %v, expected %v", test.transform, c, test.cmyk)
+        }
+    }
+    res, e := img.image(AdobeTransformYCCK)
+    if e != nil {
+        t.Fatalf("image(YCCK): %v", e)
+    }
+    r, g, b := color.YCbCrToRGB(200, 100, 50)
+    if c := res.(*image.CMYK).CMYKAt(3, 3); c != (color.CMYK{r, g, b, 225}) {
+        t.Errorf("image(YCCK): %v", c)
+    }
+}
+
+// a reader failing as soon as it is asked for the scan data
+type headerOnlyReader struct {
+    data []byte
+    limit int
+}
+func (r *headerOnlyReader) Read(b []byte) (int, error) {
+    if len(r.data) <= r.limit {
+        return 0, fmt.Errorf("scan data read")
+    }
+    n := copy(b, r.data[:len(r.data) - r.limit])
+    r.data = r.data[n:]
+    return n, nil
+}
+
+func TestDecodeConfig(t *testing.T) {
+    data := testJpeg(t, 77, 45)
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    var sos Entry
+    for _, entry := range X.Entries {
+        if entry.GetId() == SOS { sos = entry; }
+    }
+    cfg, e := DecodeConfig(&headerOnlyReader{data, len(data) - int(sos.Pos())})
+    if e != nil {
+        t.Fatalf("DecodeConfig(): %v", e)
+    }
+    if cfg.Width != 77 || cfg.Height != 45 || cfg.ColorModel != color.RGBAModel {
+        t.Errorf("Got config %+v", cfg)
+    }
+    img, e := Decode(bytes.NewReader(data))
+    if e != nil {
+        t.Fatalf("Decode(): %v", e)
+    }
+    if b := img.Bounds(); b.Dx() != 77 || b.Dy() != 45 {
+        t.Errorf("Got bounds %v", b)
+    }
+
+    // 8x8 grey progressive image, a single DC scan of a zero difference
+    progressive := []byte{0xff, SOI, 0xff, DQT, 0, 67, 0}
+    progressive = append(progressive, bytes.Repeat([]byte{1}, 64)...)
+    progressive = append(progressive,
+        0xff, DHT, 0, 20, 0x00, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+        0xff, SOF2, 0, 11, 8, 0, 8, 0, 8, 1, 1, 0x11, 0,
+        0xff, SOS, 0, 8, 1, 1, 0x00, 0, 0, 0, 0x7f,
+        0xff, EOI)
+    var P Jfif
+    if e = P.Parse(progressive); e != nil {
+        t.Fatalf("Cannot parse progressive: %v", e)
+    }
+    if _, e = P.Decode(); e == nil || !strings.Contains(e.Error(), "unsupported frame type") {
+        t.Errorf("Jfif.Decode() of progressive succeeded")
+    }
+    if img, e = Decode(bytes.NewReader(progressive)); e != nil {
+        t.Fatalf("Decode() of progressive: %v", e)
+    }
+    if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 8 {
+        t.Errorf("Got progressive bounds %v", b)
+    }
+}
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
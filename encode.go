@@ -0,0 +1,282 @@
+package jfif
+
+import (
+    "fmt"
+    "errors"
+    "math/bits"
+)
+
+var errMissingSymbol = errors.New("symbol missing in Huffman table")
+
+type huffEncoder struct {
+    code [256]uint16
+    size [256]uint
+}
+
+func newHuffEncoder(ht *HuffmanTable) (*huffEncoder, error) {
+    codes, e := ht.Codes()
+    if e != nil {
+        return nil, e
+    }
+    enc := new(huffEncoder)
+    for _, c := range codes {
+        enc.code[c.Symbol], enc.size[c.Symbol] = c.Code, uint(c.Size)
+    }
+    return enc, nil
+}
+
+// entropy-coded data writer, stuffs FF 00
+type bitWriter struct {
+    out []byte
+    acc uint32
+    n uint
+}
+
+func (bw *bitWriter) put(v uint32, n uint) {
+    bw.acc = bw.acc << n | v & (1 << n - 1)
+    bw.n += n
+    for bw.n >= 8 {
+        b := byte(bw.acc >> (bw.n - 8))
+        bw.out = append(bw.out, b)
+        if b == 0xff {
+            bw.out = append(bw.out, 0)
+        }
+        bw.n -= 8
+    }
+    bw.acc &= 1 << bw.n - 1
+}
+
+// pads the last byte with 1 bits
+func (bw *bitWriter) flush() {
+    if bw.n > 0 {
+        bw.put(1 << (8 - bw.n) - 1, 8 - bw.n)
+    }
+}
+
+// magnitude category of a coefficient and its extra bits (JPEG F.1.2.1)
+func category(v int32) (uint, uint32) {
+    a := v
+    if a < 0 {
+        a, v = -a, v - 1
+    }
+    s := uint(bits.Len32(uint32(a)))
+    return s, uint32(v) & (1 << s - 1)
+}
+
+// walks the scan in coding order reporting Huffman symbols with their
+// extra bits, `restart` is called before every restart marker
+func (img *coefImage) walkScan(sos *SosEntry, interval int,
+                               emit func(ci int, ac bool, sym byte, v uint32, n uint) error,
+                               restart func(n int)) error {
+    planes, e := img.scanPlanes(sos)
+    if e != nil {
+        return e
+    }
+    pred := make([]int32, len(planes))
+    last, n := 0, 0
+    return img.scanBlocks(planes, func(p *coefPlane, ci, bx, by, mcu int) error {
+        if interval > 0 && mcu != last && mcu % interval == 0 {
+            restart(n)
+            n++
+            for i := range pred { pred[i] = 0; }
+        }
+        last = mcu
+        blk := p.block(bx, by)
+        s, v := category(blk[0] - pred[ci])
+        pred[ci] = blk[0]
+        if e := emit(ci, false, byte(s), v, s); e != nil { return e; }
+        run := 0
+        for k := 1; k < 64; k++ {
+            c := blk[zigzag[k]]
+            if c == 0 {
+                run++
+                continue
+            }
+            for ; run > 15; run -= 16 {
+                if e := emit(ci, true, 0xf0, 0, 0); e != nil { return e; }
+            }
+            s, v := category(c)
+            if e := emit(ci, true, byte(run << 4) | byte(s), v, s); e != nil { return e; }
+            run = 0
+        }
+        if run > 0 {
+            return emit(ci, true, 0x00, 0, 0) // EOB
+        }
+        return nil
+    })
+}
+
+// optimal Huffman table for the symbol frequencies (JPEG Annex K.2,
+// the way libjpeg's jpeg_gen_optimal_table() does it)
+func optimalTable(freq *[256]int, class, id Byte) HuffmanTable {
+    var f [257]int
+    copy(f[:], freq[:])
+    f[256] = 1 // reserved, so no code is all 1 bits
+    var codesize [257]int
+    var others [257]int
+    for i := range others { others[i] = -1; }
+    for {
+        c1, c2 := -1, -1
+        for i := range f { // the least frequent, the last one on ties
+            if f[i] != 0 && (c1 < 0 || f[i] <= f[c1]) { c1 = i; }
+        }
+        for i := range f {
+            if f[i] != 0 && i != c1 && (c2 < 0 || f[i] <= f[c2]) { c2 = i; }
+        }
+        if c2 < 0 { break; }
+        f[c1] += f[c2]
+        f[c2] = 0
+        codesize[c1]++
+        for others[c1] >= 0 {
+            c1 = others[c1]
+            codesize[c1]++
+        }
+        others[c1] = c2
+        codesize[c2]++
+        for others[c2] >= 0 {
+            c2 = others[c2]
+            codesize[c2]++
+        }
+    }
+    var count [33]int
+    for _, size := range codesize {
+        if size > 0 { count[size]++; }
+    }
+    for i := 32; i > 16; i-- { // limit code lengths to 16 bits
+        for count[i] > 0 {
+            j := i - 2
+            for count[j] == 0 { j--; }
+            count[i] -= 2
+            count[i-1]++
+            count[j+1] += 2
+            count[j]--
+        }
+    }
+    i := 16
+    for count[i] == 0 { i--; }
+    count[i]-- // drop the reserved symbol
+    ht := HuffmanTable{Class: class, ID: id}
+    for i := 1; i <= 16; i++ {
+        ht.Counts[i-1] = byte(count[i])
+    }
+    for size := 1; size <= 32; size++ {
+        for s := 0; s < 256; s++ {
+            if codesize[s] == size { ht.Symbols = append(ht.Symbols, byte(s)); }
+        }
+    }
+    return ht
+}
+
+// a scan with the Huffman tables active at its position
+type scanJob struct {
+    sos *SosEntry
+    dc, ac []*HuffmanTable
+    interval int
+}
+
+func (x *Jfif) scanJobs() ([]scanJob, error) {
+    var jobs []scanJob
+    var dc, ac [4]*HuffmanTable
+    var interval Word
+    for _, entry := range x.Entries {
+        switch ent := entry.(type) {
+        case *DhtEntry:
+            for i := range ent.Tables {
+                ht := &ent.Tables[i]
+                if ht.Class == 0 { dc[ht.ID & 3] = ht; } else { ac[ht.ID & 3] = ht; }
+            }
+        case *DriEntry:
+            interval = ent.Interval
+        case *SosEntry:
+            job := scanJob{sos: ent, interval: int(interval)}
+            for _, c := range ent.Components {
+                d, a := dc[c.Ht >> 4 & 3], ac[c.Ht & 3]
+                if d == nil || a == nil {
+                    return nil, fmt.Errorf("no Huffman table for component %d", c.Id)
+                }
+                job.dc, job.ac = append(job.dc, d), append(job.ac, a)
+            }
+            jobs = append(jobs, job)
+        }
+    }
+    return jobs, nil
+}
+
+// re-encodes every scan of the image from `img` using the existing Huffman
+// tables; if they lack some symbols, optimal tables are generated instead
+func (x *Jfif) encodeScans(img *coefImage) error {
+    jobs, e := x.scanJobs()
+    if e != nil {
+        return e
+    }
+    encode := func() ([][]byte, error) {
+        encoders := make(map[*HuffmanTable]*huffEncoder)
+        get := func(ht *HuffmanTable) (*huffEncoder, error) {
+            if enc, ok := encoders[ht]; ok { return enc, nil; }
+            enc, e := newHuffEncoder(ht)
+            encoders[ht] = enc
+            return enc, e
+        }
+        var res [][]byte
+        for _, job := range jobs {
+            var bw bitWriter
+            emit := func(ci int, ac bool, sym byte, v uint32, n uint) error {
+                ht := job.dc[ci]
+                if ac { ht = job.ac[ci]; }
+                enc, e := get(ht)
+                if e != nil { return e; }
+                if enc.size[sym] == 0 { return errMissingSymbol; }
+                bw.put(uint32(enc.code[sym]), enc.size[sym])
+                bw.put(v, n)
+                return nil
+            }
+            restart := func(n int) {
+                bw.flush()
+                bw.out = append(bw.out, 0xff, byte(RST0 + n % 8))
+            }
+            if e := img.walkScan(job.sos, job.interval, emit, restart); e != nil {
+                return nil, e
+            }
+            bw.flush()
+            res = append(res, bw.out)
+        }
+        return res, nil
+    }
+
+    data, e := encode()
+    if e == errMissingSymbol {
+        freq := make(map[*HuffmanTable]*[256]int)
+        for _, job := range jobs {
+            for _, ht := range append(append([]*HuffmanTable{}, job.dc...), job.ac...) {
+                if freq[ht] == nil { freq[ht] = new([256]int); }
+            }
+            count := func(ci int, ac bool, sym byte, v uint32, n uint) error {
+                ht := job.dc[ci]
+                if ac { ht = job.ac[ci]; }
+                freq[ht][sym]++
+                return nil
+            }
+            if e = img.walkScan(job.sos, job.interval, count, func(int) {}); e != nil {
+                return e
+            }
+        }
+        saved := make(map[*HuffmanTable]HuffmanTable)
+        for ht, f := range freq {
+            saved[ht] = *ht
+            *ht = optimalTable(f, ht.Class, ht.ID)
+        }
+        if data, e = encode(); e != nil {
+            for ht, old := range saved { *ht = old; }
+            return e
+        }
+    }
+    if e != nil {
+        return e
+    }
+    for i, job := range jobs {
+        job.sos.Image = data[i]
+    }
+    return nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -1,7 +1,7 @@
 package jfif
 
 import (
-    "os"
+    "io"
     "fmt"
     "encoding/binary"
 )
@@ -135,10 +135,18 @@ var EntryName = map[Byte]string{
     EOI: "EOI",
 }
 
+// the Length field value for a segment with `n` bytes of payload
+func segmentLength(id Byte, n int) (Word, error) {
+    if n > 65535 - 2 {
+        return 0, fmt.Errorf("%s payload of %d bytes exceeds %d bytes limit", EntryName[id], n, 65535 - 2)
+    }
+    return Word(n + 2), nil
+}
+
 type Entry interface {
     IsValid() bool
     // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-    Read(fd *os.File) error
+    Read(fd SeekingReader) error
     HasData() bool
     GetData() []byte
     GetId() Byte
@@ -170,31 +178,36 @@ func (lkp *lookupHeader) IsValid() bool {
            lkp.XID == SOFf ||
            lkp.XID == DHT  ||
            lkp.XID == SOS  ||
+           lkp.XID == DRI  ||
+           lkp.XID == COM  ||
            lkp.XID == EOI)
 }
-func (lkp *lookupHeader) Read(fd *os.File) error {
-    if e := ReadStructHere(fd, lkp); e != nil {
+func (lkp *lookupHeader) Read(fd SeekingReader) error {
+    pos, e := Tell(fd)
+    if e != nil {
         return e
     }
+    if e = ReadStructHere(fd, lkp); e != nil {
+        return readError(pos, lkp.XID, e)
+    }
     if !lkp.IsValid() {
-        return fmt.Errorf("Invalid entry %+v", lkp)
+        return &FormatError{Offset: pos, Marker: lkp.XID, Reason: "unknown marker", Err: ErrUnknownMarker}
     }
     return nil
 }
-func (lkp *lookupHeader) ReadData(fd *os.File) ([]byte, error) {
-    var e error
-    if lkp.Len == 0 {
-        return nil, nil
-    }
+func (lkp *lookupHeader) ReadData(fd SeekingReader) ([]byte, error) {
     pos, e := Tell(fd)
     if e != nil {
         return nil, e
     }
+    if lkp.Len < 2 {
+        return nil, badSegment(pos, lkp.XID, "bad length %d", lkp.Len)
+    }
     if _, e = fd.Seek(pos + 4, 0); e != nil {
         return nil, e
     }
     data := make([]byte, lkp.Len - 2)
-    if _, e = fd.Read(data); e != nil {
+    if _, e = io.ReadFull(fd, data); e != nil {
         return nil, e
     }
     return data, nil
@@ -217,31 +230,40 @@ func (ent *anEntry) IsValid() bool {
            ent.XID == SOF7 || ent.XID == SOF9 || ent.XID == SOFa ||
            ent.XID == SOFb || ent.XID == SOFd || ent.XID == SOFe ||
            ent.XID == SOFf ||
-           ent.XID == DHT || ent.XID == SOS || ent.XID == EOI)
+           ent.XID == DHT || ent.XID == SOS || ent.XID == DRI ||
+           ent.XID == COM || ent.XID == EOI)
 }
 // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-func (ent *anEntry) ReadEntry(fd *os.File) (Entry, error) {
-    if e := ReadStructHere(fd, ent); e != nil {
+func (ent *anEntry) ReadEntry(fd SeekingReader) (Entry, error) {
+    pos, e := Tell(fd)
+    if e != nil {
         return nil, e
     }
-    if !ent.IsValid() {
-        return nil, fmt.Errorf("Invalid entry %+v", ent)
+    if e = ReadStructHere(fd, ent); e != nil {
+        return nil, readError(pos, 0, e)
+    }
+    if ent.Xff0 != 255 {
+        return nil, badSegment(pos, 0, "marker expected, got %02X", ent.Xff0)
     }
+    var nent Entry
     switch ent.XID {
-    case SOI : { nent := new(SoiEntry); return nent, nent.Read(fd); }
-    case APP0: { nent := new(App0Entry); return nent, nent.Read(fd); }
+    case SOI : nent = new(SoiEntry)
+    case APP0: nent = new(App0Entry)
     case APP1, APP2, APP3, APP4, APP5, APP6, APP7, APP8, APP9, APPa, APPb, APPc,
          APPd, APPe, APPf:
-         { nent := new(AppnEntry); return nent, nent.Read(fd); }
-    case DQT : { nent := new(DqtEntry); return nent, nent.Read(fd); }
-    case SOF0, SOF1, SOF2, SOF3, SOF5, SOF6, SOF7, SOFa, SOFb, SOFd, SOFe, SOFf:
-        { nent := new(SofEntry); return nent, nent.Read(fd); }
-    case DHT : { nent := new(DhtEntry); return nent, nent.Read(fd); }
-    case SOS : { nent := new(SosEntry); return nent, nent.Read(fd); }
-    case EOI : { nent := new(EoiEntry); return nent, nent.Read(fd); }
+         nent = new(AppnEntry)
+    case DQT : nent = new(DqtEntry)
+    case SOF0, SOF1, SOF2, SOF3, SOF5, SOF6, SOF7, SOF9, SOFa, SOFb, SOFd, SOFe, SOFf:
+        nent = new(SofEntry)
+    case DHT : nent = new(DhtEntry)
+    case SOS : nent = new(SosEntry)
+    case DRI : nent = new(DriEntry)
+    case COM : nent = new(CommentEntry)
+    case EOI : nent = new(EoiEntry)
+    default:
+        return nil, &FormatError{Offset: pos, Marker: ent.XID, Reason: "unknown marker", Err: ErrUnknownMarker}
     }
-
-    return nil, fmt.Errorf("Shit happened in entry %+v", ent)
+    return nent, readError(pos, ent.XID, nent.Read(fd))
 }
 
 type SoiEntry struct {
@@ -266,19 +288,19 @@ func (soi *SoiEntry) IsValid() bool {
     return soi.Xff0 == 255 && soi.ID == SOI
 }
 // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-func (soi *SoiEntry) Read(fd *os.File) error {
+func (soi *SoiEntry) Read(fd SeekingReader) error {
     var e error
-    if soi.pos, e = fd.Seek(0, 0); e != nil {
+    if soi.pos, e = Tell(fd); e != nil {
         return e
     }
     var tmp = make([]byte, 2)
-    if _, e = fd.Read(tmp); e != nil {
+    if _, e = io.ReadFull(fd, tmp); e != nil {
         return e
     }
     soi.Xff0 = Byte(tmp[0])
     soi.ID = Byte(tmp[1])
     if !soi.IsValid() {
-        return fmt.Errorf("Invalid SOI entry %+v", soi)
+        return badSegment(soi.pos, soi.ID, "invalid header")
     }
     return nil // no .Length, no "tail"
 }
@@ -298,10 +320,13 @@ type App0Entry struct {
 }
 func (app0 *App0Entry) HasData() bool { return app0.Data != nil; }
 func (app0 *App0Entry) Pos() int64 { return app0.pos; }
-func (app0 *App0Entry) Len() int64 { return int64(app0.Length) + 2; }
+func (app0 *App0Entry) Len() int64 { return int64(app0.payloadSize()) + 4; }
+func (app0 *App0Entry) payloadSize() int { return 14 + len(app0.Data); }
 func (app0 *App0Entry) GetId() Byte { return app0.ID; }
 func (app0 *App0Entry) Write(fd Writer) error {
-    var e error
+    length, e := segmentLength(app0.ID, app0.payloadSize())
+    if e != nil { return e; }
+    app0.Length = length
     if e = WriteByte(fd, app0.Xff0); e != nil { return e; }
     if e = WriteByte(fd, app0.ID); e != nil { return e; }
     if e = WriteWordBE(fd, app0.Length); e != nil { return e; }
@@ -335,7 +360,7 @@ func (app0 *App0Entry) IsValid() bool {
            (app0.Units == 0 || app0.Units == 1 || app0.Units == 2)
 }
 // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-func (app0 *App0Entry) Read(fd *os.File) error {
+func (app0 *App0Entry) Read(fd SeekingReader) error {
     app0.pos, _ = Tell(fd)
     var tmp struct {
         Xff0 Byte   // +0
@@ -362,13 +387,13 @@ func (app0 *App0Entry) Read(fd *os.File) error {
     app0.Data = nil
 
     if !app0.IsValid() {
-        return fmt.Errorf("Invalid header %+v", app0)
+        return badSegment(app0.pos, app0.ID, "invalid header")
     }
 
     tsize := 3 * int(app0.Xthumbnail) * int(app0.Ythumbnail)
     if tsize > 0 {
         app0.Data = make([]byte, tsize)
-        if _, e := fd.Read(app0.Data); e != nil {
+        if _, e := io.ReadFull(fd, app0.Data); e != nil {
             return e
         }
     }
@@ -386,10 +411,13 @@ type AppnEntry struct {
 }
 func (app *AppnEntry) HasData() bool { return app.Data != nil; }
 func (app *AppnEntry) Pos() int64 { return app.pos; }
-func (app *AppnEntry) Len() int64 { return int64(app.Length) + 2; }
+func (app *AppnEntry) Len() int64 { return int64(app.payloadSize()) + 4; }
+func (app *AppnEntry) payloadSize() int { return len(app.Data); }
 func (app *AppnEntry) GetId() Byte { return app.ID; }
 func (app *AppnEntry) Write(fd Writer) error {
-    var e error
+    length, e := segmentLength(app.ID, app.payloadSize())
+    if e != nil { return e; }
+    app.Length = length
     if e = WriteByte(fd, app.Xff0); e != nil { return e; }
     if e = WriteByte(fd, app.ID); e != nil { return e; }
     if e = WriteWordBE(fd, app.Length); e != nil { return e; }
@@ -399,6 +427,15 @@ func (app *AppnEntry) Write(fd Writer) error {
 }
 func (app *AppnEntry) String() string { return fmt.Sprintf(EntryStringFormat, EntryName[app.ID], app); }
 func (app *AppnEntry) GetData() []byte { return app.Data; }
+// the NUL-terminated identifier the payload starts with ("Exif",
+// "ICC_PROFILE" etc.), empty if there is none
+func (app *AppnEntry) Signature() string {
+    for i, b := range app.Data {
+        if b == 0 { return string(app.Data[:i]); }
+        if b < 0x20 || b > 0x7e || i >= 80 { break; }
+    }
+    return ""
+}
 func (app *AppnEntry) IsValid() bool {
     return app.Xff0 == 255 &&
           (app.ID == APP1 || app.ID == APP2 || app.ID == APP3 ||
@@ -408,7 +445,7 @@ func (app *AppnEntry) IsValid() bool {
            app.ID == APPd || app.ID == APPe || app.ID == APPf)
 }
 // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-func (app *AppnEntry) Read(fd *os.File) error {
+func (app *AppnEntry) Read(fd SeekingReader) error {
     app.pos, _ = Tell(fd)
     var e error
     var tmp lookupHeader
@@ -420,7 +457,7 @@ func (app *AppnEntry) Read(fd *os.File) error {
     app.Length = tmp.Len
 
     if !app.IsValid() {
-        return fmt.Errorf("Invalid header %+v", app)
+        return badSegment(app.pos, app.ID, "invalid header")
     }
 
     app.Data, e = tmp.ReadData(fd)
@@ -431,36 +468,69 @@ func (app *AppnEntry) Read(fd *os.File) error {
     return nil
 }
 
+type QuantTable struct {
+    Precision Byte      // 0 = 8 bit, 1 = 16 bit values
+    ID Byte             // destination 0..3
+    Values [64]Word     // zigzag order as stored
+}
+// values in natural (row-major) order
+func (qt *QuantTable) Natural() [64]Word {
+    var res [64]Word
+    for i, v := range qt.Values { res[zigzag[i]] = v; }
+    return res
+}
+func (qt *QuantTable) String() string {
+    return fmt.Sprintf("<%d/%d %v>", qt.Precision, qt.ID, qt.Values)
+}
+
 type DqtEntry struct {
     Xff0 Byte
     ID Byte
     Length Word
-    Data []byte
+
+    Tables []QuantTable
 
     pos int64
 }
-func (dqt *DqtEntry) HasData() bool { return dqt.Data != nil; }
+func (dqt *DqtEntry) HasData() bool { return len(dqt.Tables) > 0; }
 func (dqt *DqtEntry) Pos() int64 { return dqt.pos; }
-func (dqt *DqtEntry) Len() int64 { return int64(dqt.Length) + 2; }
+func (dqt *DqtEntry) Len() int64 { return int64(dqt.payloadSize()) + 4; }
+func (dqt *DqtEntry) payloadSize() int { return len(dqt.GetData()); }
 func (dqt *DqtEntry) GetId() Byte { return dqt.ID; }
 func (dqt *DqtEntry) Write(fd Writer) error {
-    var e error
+    length, e := segmentLength(dqt.ID, dqt.payloadSize())
+    if e != nil { return e; }
+    dqt.Length = length
     if e = WriteByte(fd, dqt.Xff0); e != nil { return e; }
     if e = WriteByte(fd, dqt.ID); e != nil { return e; }
     if e = WriteWordBE(fd, dqt.Length); e != nil { return e; }
-    if _, e = fd.Write(dqt.Data); e != nil { return e; }
+    if _, e = fd.Write(dqt.GetData()); e != nil { return e; }
 
     return nil
 }
 func (dqt *DqtEntry) String() string {
-    return fmt.Sprintf("<%s:%d[%d] data=%+v>", EntryName[dqt.ID], dqt.Pos(), dqt.Length, dqt.Data)
+    return fmt.Sprintf("<%s:%d[%d] tables=%v>", EntryName[dqt.ID], dqt.Pos(), dqt.Length, dqt.Tables)
+}
+// the tables as stored in the segment
+func (dqt *DqtEntry) GetData() []byte {
+    var data []byte
+    for _, qt := range dqt.Tables {
+        data = append(data, byte(qt.Precision << 4 | qt.ID & 15))
+        for _, v := range qt.Values {
+            if qt.Precision == 0 {
+                data = append(data, byte(v))
+            } else {
+                data = append(data, byte(v >> 8), byte(v))
+            }
+        }
+    }
+    return data
 }
-func (dqt *DqtEntry) GetData() []byte { return dqt.Data; }
 func (dqt *DqtEntry) IsValid() bool {
     return dqt.Xff0 == 255 && dqt.ID == DQT
 }
 // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-func (dqt *DqtEntry) Read(fd *os.File) error {
+func (dqt *DqtEntry) Read(fd SeekingReader) error {
     dqt.pos, _ = Tell(fd)
     var e error
     var tmp lookupHeader
@@ -472,17 +542,44 @@ func (dqt *DqtEntry) Read(fd *os.File) error {
     dqt.Length = tmp.Len
 
     if !dqt.IsValid() {
-        return fmt.Errorf("Invalid header %+v", dqt)
+        return badSegment(dqt.pos, dqt.ID, "invalid header")
     }
 
-    dqt.Data, e = tmp.ReadData(fd)
+    data, e := tmp.ReadData(fd)
     if e != nil {
         return e
     }
+    dqt.Tables = nil
+    for len(data) > 0 {
+        var qt QuantTable
+        qt.Precision, qt.ID = Byte(data[0] >> 4), Byte(data[0] & 15)
+        if qt.Precision > 1 || qt.ID > 3 {
+            return badSegment(dqt.pos, dqt.ID, "bad table precision/id %d/%d", qt.Precision, qt.ID)
+        }
+        size := 64 * (int(qt.Precision) + 1)
+        if len(data) < 1 + size {
+            return truncated(dqt.pos, dqt.ID, "quantization table")
+        }
+        for i := range qt.Values {
+            if qt.Precision == 0 {
+                qt.Values[i] = Word(data[1+i])
+            } else {
+                qt.Values[i] = GetWordBE(data[1+2*i:])
+            }
+        }
+        data = data[1+size:]
+        dqt.Tables = append(dqt.Tables, qt)
+    }
 
     return nil
 }
 
+type FrameComponent struct {
+    ID Byte
+    H, V Byte   // sampling factors 1..4
+    Tq Byte     // quantization table destination
+}
+
 type SofEntry struct {
     Xff0 Byte
     ID Byte
@@ -490,36 +587,47 @@ type SofEntry struct {
     Precision Byte
     Height Word
     Width Word
-    Components Byte
-    Data []byte
+    ComponentCount Byte
+    Components []FrameComponent
 
     pos int64
 }
-func (sof *SofEntry) HasData() bool { return sof.Data != nil; }
+func (sof *SofEntry) HasData() bool { return len(sof.Components) > 0; }
 func (sof *SofEntry) Pos() int64 { return sof.pos; }
-func (sof *SofEntry) Len() int64 { return int64(sof.Length) + 2; }
+func (sof *SofEntry) Len() int64 { return int64(sof.payloadSize()) + 4; }
+func (sof *SofEntry) payloadSize() int { return 6 + len(sof.GetData()); }
 func (sof *SofEntry) GetId() Byte { return sof.ID; }
 func (sof *SofEntry) Write(fd Writer) error {
-    var e error
+    length, e := segmentLength(sof.ID, sof.payloadSize())
+    if e != nil { return e; }
+    sof.Length = length
     if e = WriteByte(fd, sof.Xff0); e != nil { return e; }
     if e = WriteByte(fd, sof.ID); e != nil { return e; }
     if e = WriteWordBE(fd, sof.Length); e != nil { return e; }
     if e = WriteByte(fd, sof.Precision); e != nil { return e; }
-    if e = WriteWordLE(fd, sof.Height); e != nil { return e; }
-    if e = WriteWordLE(fd, sof.Width); e != nil { return e; }
-    if e = WriteByte(fd, sof.Components); e != nil { return e; }
-    if _, e = fd.Write(sof.Data); e != nil { return e; }
+    if e = WriteWordBE(fd, sof.Height); e != nil { return e; }
+    if e = WriteWordBE(fd, sof.Width); e != nil { return e; }
+    if e = WriteByte(fd, sof.ComponentCount); e != nil { return e; }
+    if _, e = fd.Write(sof.GetData()); e != nil { return e; }
 
     return nil
 }
 func (sof *SofEntry) String() string {
-    return fmt.Sprintf("<%s:%d[%d] b/px=%d (H%d x W%d) %d:data=%+v>",
+    return fmt.Sprintf("<%s:%d[%d] b/px=%d (H%d x W%d) %s components[%d]%v>",
                        EntryName[sof.ID], sof.Pos(), sof.Length,
                        sof.Precision,
                        sof.Height, sof.Width,
-                       sof.Components, sof.Data)
+                       sof.Subsampling(),
+                       sof.ComponentCount, sof.Components)
+}
+// the component specifications as stored in the segment
+func (sof *SofEntry) GetData() []byte {
+    var data []byte
+    for _, c := range sof.Components {
+        data = append(data, byte(c.ID), byte(c.H << 4 | c.V & 15), byte(c.Tq))
+    }
+    return data
 }
-func (sof *SofEntry) GetData() []byte { return sof.Data; }
 func (sof *SofEntry) IsValid() bool {
     return sof.Xff0 == 255 && (
            sof.ID == SOF0 || sof.ID == SOF1 || sof.ID == SOF2 ||
@@ -528,8 +636,45 @@ func (sof *SofEntry) IsValid() bool {
            sof.ID == SOFb || sof.ID == SOFd || sof.ID == SOFe ||
            sof.ID == SOFf)
 }
+// maximal sampling factors over the components
+func (sof *SofEntry) MaxSampling() (H, V Byte) {
+    H, V = 1, 1
+    for _, c := range sof.Components {
+        if c.H > H { H = c.H; }
+        if c.V > V { V = c.V; }
+    }
+    return H, V
+}
+// MCU size in pixels; a single component image is not interleaved and has
+// 8x8 MCUs whatever its sampling factors are
+func (sof *SofEntry) McuSize() (w, h int) {
+    if len(sof.Components) == 1 {
+        return 8, 8
+    }
+    H, V := sof.MaxSampling()
+    return 8 * int(H), 8 * int(V)
+}
+// chroma subsampling in J:a:b notation ("4:2:0" etc.), "4:0:0" for grayscale
+func (sof *SofEntry) Subsampling() string {
+    if len(sof.Components) < 3 {
+        return "4:0:0"
+    }
+    y, c := sof.Components[0], sof.Components[1]
+    if c.H == 0 || c.V == 0 || y.H % c.H != 0 || y.V % c.V != 0 {
+        return fmt.Sprintf("%dx%d/%dx%d", y.H, y.V, c.H, c.V)
+    }
+    switch [2]Byte{y.H / c.H, y.V / c.V} {
+    case [2]Byte{1, 1}: return "4:4:4"
+    case [2]Byte{2, 1}: return "4:2:2"
+    case [2]Byte{2, 2}: return "4:2:0"
+    case [2]Byte{4, 1}: return "4:1:1"
+    case [2]Byte{1, 2}: return "4:4:0"
+    case [2]Byte{4, 2}: return "4:1:0"
+    }
+    return fmt.Sprintf("%dx%d/%dx%d", y.H, y.V, c.H, c.V)
+}
 // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-func (sof *SofEntry) Read(fd *os.File) error {
+func (sof *SofEntry) Read(fd SeekingReader) error {
     sof.pos, _ = Tell(fd)
     var e error
     var tmp lookupHeader
@@ -541,60 +686,120 @@ func (sof *SofEntry) Read(fd *os.File) error {
     sof.Length = tmp.Len
 
     if !sof.IsValid() {
-        return fmt.Errorf("Invalid header %+v", sof)
+        return badSegment(sof.pos, sof.ID, "invalid header")
     }
 
     data, e := tmp.ReadData(fd)
     if e != nil {
         return e
     }
+    if len(data) < 6 {
+        return truncated(sof.pos, sof.ID, "frame header")
+    }
     sof.Precision, data = Byte(data[0]), data[1:]
-    sof.Height, data = GetWordLE(data), data[2:]
-    sof.Width, data = GetWordLE(data), data[2:]
-    sof.Components, data = Byte(data[0]), data[1:]
-    sof.Data = data
+    sof.Height, data = GetWordBE(data), data[2:]
+    sof.Width, data = GetWordBE(data), data[2:]
+    sof.ComponentCount, data = Byte(data[0]), data[1:]
+    if len(data) != 3 * int(sof.ComponentCount) {
+        return badSegment(sof.pos, sof.ID, "bad component count %d", sof.ComponentCount)
+    }
+    sof.Components = nil
+    for i := 0; i < int(sof.ComponentCount); i++ {
+        c := FrameComponent{ID: Byte(data[0]), H: Byte(data[1] >> 4), V: Byte(data[1] & 15), Tq: Byte(data[2])}
+        if c.H < 1 || c.H > 4 || c.V < 1 || c.V > 4 || c.Tq > 3 {
+            return badSegment(sof.pos, sof.ID, "bad component %+v", c)
+        }
+        sof.Components = append(sof.Components, c)
+        data = data[3:]
+    }
 
     return nil
 }
 
+type HuffmanTable struct {
+    Class Byte          // 0 = DC, 1 = AC
+    ID Byte             // destination 0..3
+    Counts [16]byte     // number of codes of each length 1..16
+    Symbols []byte
+}
+type HuffmanCode struct {
+    Symbol byte
+    Code uint16
+    Size int // bits
+}
+// canonical codes in Symbols order (see JPEG Annex C)
+func (ht *HuffmanTable) Codes() ([]HuffmanCode, error) {
+    var res []HuffmanCode
+    code, k := 0, 0
+    for size := 1; size <= 16; size++ {
+        for i := 0; i < int(ht.Counts[size-1]); i++ {
+            if k >= len(ht.Symbols) {
+                return nil, fmt.Errorf("Huffman table %d/%d: too few symbols", ht.Class, ht.ID)
+            }
+            if code >= 1 << uint(size) {
+                return nil, fmt.Errorf("Huffman table %d/%d: code overflow", ht.Class, ht.ID)
+            }
+            res = append(res, HuffmanCode{Symbol: ht.Symbols[k], Code: uint16(code), Size: size})
+            code++
+            k++
+        }
+        code <<= 1
+    }
+    return res, nil
+}
+func (ht *HuffmanTable) count() int {
+    sum := 0
+    for _, b := range ht.Counts { sum += int(b); }
+    return sum
+}
+func (ht *HuffmanTable) String() string {
+    return fmt.Sprintf("<%d/%d %v %v>", ht.Class, ht.ID, ht.Counts, ht.Symbols)
+}
+
 type DhtEntry struct {
     Xff0 Byte
     ID Byte
     Length Word
 
-    HtInfo Byte
-    NumOfSymbols []byte
-
-    Data []byte
+    Tables []HuffmanTable
 
     pos int64
 }
-func (dht *DhtEntry) HasData() bool { return dht.Data != nil; }
+func (dht *DhtEntry) HasData() bool { return len(dht.Tables) > 0; }
 func (dht *DhtEntry) Pos() int64 { return dht.pos; }
-func (dht *DhtEntry) Len() int64 { return int64(dht.Length) + 2; }
+func (dht *DhtEntry) Len() int64 { return int64(dht.payloadSize()) + 4; }
+func (dht *DhtEntry) payloadSize() int { return len(dht.GetData()); }
 func (dht *DhtEntry) GetId() Byte { return dht.ID; }
 func (dht *DhtEntry) Write(fd Writer) error {
-    var e error
+    length, e := segmentLength(dht.ID, dht.payloadSize())
+    if e != nil { return e; }
+    dht.Length = length
     if e = WriteByte(fd, dht.Xff0); e != nil { return e; }
     if e = WriteByte(fd, dht.ID); e != nil { return e; }
     if e = WriteWordBE(fd, dht.Length); e != nil { return e; }
-    if e = WriteByte(fd, dht.HtInfo); e != nil { return e; }
-    if _, e = fd.Write(dht.NumOfSymbols); e != nil { return e; }
-    if _, e = fd.Write(dht.Data); e != nil { return e; }
+    if _, e = fd.Write(dht.GetData()); e != nil { return e; }
 
     return nil
 }
 func (dht *DhtEntry) String() string {
-    return fmt.Sprintf("<%s:%d[%d] %02x %v data=%+v>",
-                       EntryName[dht.ID], dht.Pos(), dht.Length,
-                       dht.HtInfo, dht.NumOfSymbols, dht.Data)
+    return fmt.Sprintf("<%s:%d[%d] tables=%v>",
+                       EntryName[dht.ID], dht.Pos(), dht.Length, dht.Tables)
+}
+// the tables as stored in the segment
+func (dht *DhtEntry) GetData() []byte {
+    var data []byte
+    for _, ht := range dht.Tables {
+        data = append(data, byte(ht.Class << 4 | ht.ID & 15))
+        data = append(data, ht.Counts[:]...)
+        data = append(data, ht.Symbols...)
+    }
+    return data
 }
-func (dht *DhtEntry) GetData() []byte { return dht.Data; }
 func (dht *DhtEntry) IsValid() bool {
     return dht.Xff0 == 255 && dht.ID == DHT
 }
 // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-func (dht *DhtEntry) Read(fd *os.File) error {
+func (dht *DhtEntry) Read(fd SeekingReader) error {
     dht.pos, _ = Tell(fd)
     var e error
     var tmp lookupHeader
@@ -606,22 +811,83 @@ func (dht *DhtEntry) Read(fd *os.File) error {
     dht.Length = tmp.Len
 
     if !dht.IsValid() {
-        return fmt.Errorf("Invalid header %+v", dht)
+        return badSegment(dht.pos, dht.ID, "invalid header")
     }
 
     data, e := tmp.ReadData(fd)
     if e != nil {
         return e
     }
-    dht.HtInfo, data = Byte(data[0]), data[1:]
-    dht.NumOfSymbols, data = data[0:16], data[16:]
-    dht.Data = data
+    dht.Tables = nil
+    for len(data) > 0 {
+        if len(data) < 17 { return truncated(dht.pos, dht.ID, "Huffman table"); }
+        var ht HuffmanTable
+        ht.Class, ht.ID = Byte(data[0] >> 4), Byte(data[0] & 15)
+        copy(ht.Counts[:], data[1:17])
+        data = data[17:]
+        sum := ht.count()
+        if sum > 256 { return badSegment(dht.pos, dht.ID, "bad NumOfSymbols %v > 256", sum); }
+        if len(data) < sum { return truncated(dht.pos, dht.ID, "Huffman table"); }
+        ht.Symbols, data = data[:sum], data[sum:]
+        if ht.Class > 1 || ht.ID > 3 { return badSegment(dht.pos, dht.ID, "bad table class/id %d/%d", ht.Class, ht.ID); }
+        dht.Tables = append(dht.Tables, ht)
+    }
 
-    if len(dht.NumOfSymbols) != 16 { return fmt.Errorf("Bad NumOfSymbols in %+v", dht); }
-    sum := 0
-    for _, b := range dht.NumOfSymbols { sum += int(b); }
-    if sum > 256 { return fmt.Errorf("Bad NumOfSymbols in %+v (%v > 256)", dht, sum); }
-    if len(dht.Data) != sum { return fmt.Errorf("Bad NumOfSymbols in %+v: %v != %v", dht, len(dht.Data), sum); }
+    return nil
+}
+
+type DriEntry struct {
+    Xff0 Byte
+    ID Byte
+    Length Word
+    Interval Word // MCUs per restart interval, 0 disables restart markers
+
+    pos int64
+}
+func (dri *DriEntry) HasData() bool { return false; }
+func (dri *DriEntry) Pos() int64 { return dri.pos; }
+func (dri *DriEntry) Len() int64 { return int64(dri.payloadSize()) + 4; }
+func (dri *DriEntry) payloadSize() int { return 2; }
+func (dri *DriEntry) GetId() Byte { return dri.ID; }
+func (dri *DriEntry) Write(fd Writer) error {
+    length, e := segmentLength(dri.ID, dri.payloadSize())
+    if e != nil { return e; }
+    dri.Length = length
+    if e = WriteByte(fd, dri.Xff0); e != nil { return e; }
+    if e = WriteByte(fd, dri.ID); e != nil { return e; }
+    if e = WriteWordBE(fd, dri.Length); e != nil { return e; }
+    if e = WriteWordBE(fd, dri.Interval); e != nil { return e; }
+
+    return nil
+}
+func (dri *DriEntry) String() string {
+    return fmt.Sprintf("<%s:%d[%d] interval=%d>", EntryName[dri.ID], dri.Pos(), dri.Length, dri.Interval)
+}
+func (dri *DriEntry) GetData() []byte { return nil; }
+func (dri *DriEntry) IsValid() bool {
+    return dri.Xff0 == 255 && dri.ID == DRI && dri.Length == 4
+}
+// .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
+func (dri *DriEntry) Read(fd SeekingReader) error {
+    dri.pos, _ = Tell(fd)
+    var e error
+    var tmp lookupHeader
+    if e = tmp.Read(fd); e != nil {
+        return e
+    }
+    dri.Xff0 = tmp.Xff0
+    dri.ID = tmp.XID
+    dri.Length = tmp.Len
+
+    if !dri.IsValid() {
+        return badSegment(dri.pos, dri.ID, "invalid header")
+    }
+
+    data, e := tmp.ReadData(fd)
+    if e != nil {
+        return e
+    }
+    dri.Interval = GetWordBE(data)
 
     return nil
 }
@@ -646,10 +912,13 @@ type SosEntry struct {
 }
 func (sos *SosEntry) HasData() bool { return sos.Data != nil; }
 func (sos *SosEntry) Pos() int64 { return sos.pos; }
-func (sos *SosEntry) Len() int64 { return int64(sos.Length) + 2; }
+func (sos *SosEntry) Len() int64 { return int64(sos.payloadSize()) + 4; }
+func (sos *SosEntry) payloadSize() int { return 1 + 2 * len(sos.Components) + len(sos.Data); }
 func (sos *SosEntry) GetId() Byte { return sos.ID; }
 func (sos *SosEntry) Write(fd Writer) error {
-    var e error
+    length, e := segmentLength(sos.ID, sos.payloadSize())
+    if e != nil { return e; }
+    sos.Length = length
     if e = WriteByte(fd, sos.Xff0); e != nil { return e; }
     if e = WriteByte(fd, sos.ID); e != nil { return e; }
     if e = WriteWordBE(fd, sos.Length); e != nil { return e; }
@@ -666,18 +935,30 @@ func (sos *SosEntry) Write(fd Writer) error {
     return nil
 }
 func (sos *SosEntry) String() string {
-    return fmt.Sprintf("<%s:%d[%d] components[%d]%v data=%v><IMAGE[%d]>",
+    ss, se := sos.SpectralSelection()
+    ah, al := sos.SuccessiveApproximation()
+    return fmt.Sprintf("<%s:%d[%d] components[%d]%v Ss=%d Se=%d Ah=%d Al=%d><IMAGE[%d]>",
                        EntryName[sos.ID], sos.Pos(), sos.Length,
                        sos.ComponentCount, sos.Components,
-                       sos.Data,
+                       ss, se, ah, al,
                        len(sos.Image))
 }
+// first and last DCT coefficient of the scan (0 and 63 for sequential ones)
+func (sos *SosEntry) SpectralSelection() (Ss, Se Byte) {
+    if len(sos.Data) < 2 { return 0, 63; }
+    return Byte(sos.Data[0]), Byte(sos.Data[1])
+}
+// successive approximation bit positions high and low (0 for sequential)
+func (sos *SosEntry) SuccessiveApproximation() (Ah, Al Byte) {
+    if len(sos.Data) < 3 { return 0, 0; }
+    return Byte(sos.Data[2] >> 4), Byte(sos.Data[2] & 15)
+}
 func (sos *SosEntry) GetData() []byte { return sos.Data; }
 func (sos *SosEntry) IsValid() bool {
     return sos.Xff0 == 255 && sos.ID == SOS
 }
 // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-func (sos *SosEntry) Read(fd *os.File) error {
+func (sos *SosEntry) Read(fd SeekingReader) error {
     sos.pos, _ = Tell(fd)
     var e error
     var tmp lookupHeader
@@ -689,13 +970,16 @@ func (sos *SosEntry) Read(fd *os.File) error {
     sos.Length = tmp.Len
 
     if !sos.IsValid() {
-        return fmt.Errorf("Invalid header %+v", sos)
+        return badSegment(sos.pos, sos.ID, "invalid header")
     }
 
     data, e := tmp.ReadData(fd)
     if e != nil {
         return e
     }
+    if len(data) < 1 || len(data) < 1 + 2 * int(data[0]) {
+        return truncated(sos.pos, sos.ID, "scan header")
+    }
     sos.ComponentCount, data = Byte(data[0]), data[1:]
     for i := 0; i < int(sos.ComponentCount); i++ {
         c := new (SosComponent)
@@ -704,37 +988,134 @@ func (sos *SosEntry) Read(fd *os.File) error {
     }
     sos.Data = data
 
+    // the entropy-coded data runs up to the next marker that is neither
+    // a stuffed FF 00, nor RSTn, nor a fill byte
+    start, e := Tell(fd)
+    if e != nil {
+        return e
+    }
     var image []byte
-    var oxff byte
-    var b = make([]byte, 1)
-    var img_size uint64 = 0
-
+    var buf = make([]byte, 4096)
     for {
-        _, e = fd.Read(b)
-        if e != nil {
-            return e
+        n, re := fd.Read(buf)
+        for _, b := range buf[:n] {
+            if len(image) > 0 && image[len(image) - 1] == 0xff &&
+               b != 0 && b != 0xff && (b < RST0 || b > RST7) {
+                image = image[:len(image) - 1]
+                if _, e = fd.Seek(start + int64(len(image)), 0); e != nil {
+                    return e
+                }
+                sos.Image = image
+                return nil
+            }
+            image = append(image, b)
         }
-        img_size += 1
-        if img_size == 1 {
-            oxff = b[0]
-            continue
+        if re == io.EOF {
+            return io.ErrUnexpectedEOF
         }
-        if oxff == 255 && b[0] == EOI {
-            img_size -= 2
-            _, e = fd.Seek(-2, 1)
-            if e != nil {
-                return e
-            }
-            break
+        if re != nil {
+            return re
         }
-        image = append(image, oxff)
-        oxff = b[0]
-    } // here only via `break`
-    sos.Image = image
+    }
+}
+
+type CommentEntry struct {
+    Xff0 Byte
+    ID Byte
+    Length Word
+    Data []byte
+
+    pos int64
+}
+func NewCommentEntry(text string) (*CommentEntry, error) {
+    if len(text) > 65535 - 2 {
+        return nil, fmt.Errorf("Comment too long (%d bytes)", len(text))
+    }
+    return &CommentEntry{Xff0: 255, ID: COM, Length: Word(len(text) + 2), Data: []byte(text)}, nil
+}
+func (com *CommentEntry) HasData() bool { return com.Data != nil; }
+func (com *CommentEntry) Pos() int64 { return com.pos; }
+func (com *CommentEntry) Len() int64 { return int64(com.payloadSize()) + 4; }
+func (com *CommentEntry) payloadSize() int { return len(com.Data); }
+func (com *CommentEntry) GetId() Byte { return com.ID; }
+func (com *CommentEntry) Write(fd Writer) error {
+    length, e := segmentLength(com.ID, com.payloadSize())
+    if e != nil { return e; }
+    com.Length = length
+    if e = WriteByte(fd, com.Xff0); e != nil { return e; }
+    if e = WriteByte(fd, com.ID); e != nil { return e; }
+    if e = WriteWordBE(fd, com.Length); e != nil { return e; }
+    if _, e = fd.Write(com.Data); e != nil { return e; }
+
+    return nil
+}
+func (com *CommentEntry) String() string {
+    return fmt.Sprintf("<%s:%d[%d] %q>", EntryName[com.ID], com.Pos(), com.Length, com.Data)
+}
+func (com *CommentEntry) GetData() []byte { return com.Data; }
+func (com *CommentEntry) Text() string { return string(com.Data); }
+func (com *CommentEntry) IsValid() bool {
+    return com.Xff0 == 255 && com.ID == COM
+}
+// .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
+func (com *CommentEntry) Read(fd SeekingReader) error {
+    com.pos, _ = Tell(fd)
+    var e error
+    var tmp lookupHeader
+    if e = tmp.Read(fd); e != nil {
+        return e
+    }
+    com.Xff0 = tmp.Xff0
+    com.ID = tmp.XID
+    com.Length = tmp.Len
+
+    if !com.IsValid() {
+        return badSegment(com.pos, com.ID, "invalid header")
+    }
+
+    com.Data, e = tmp.ReadData(fd)
+    if e != nil {
+        return e
+    }
 
     return nil
 }
 
+// a piece of entropy-coded data between restart markers
+type RestartInterval struct {
+    Index int       // number of the interval in the scan
+    Marker Byte     // RSTn preceding the interval, 0 for the first one
+    Offset int64    // file offset of the interval data
+    Data []byte     // a slice of SosEntry.Image
+}
+// tells whether the preceding RSTn is the one expected at this Index
+func (ri *RestartInterval) InSequence() bool {
+    if ri.Index == 0 {
+        return ri.Marker == 0
+    }
+    return ri.Marker == Byte(RST0 + (ri.Index - 1) % 8)
+}
+
+// splits the scan data on RSTn markers
+func (sos *SosEntry) Intervals() []RestartInterval {
+    base := sos.Pos() + sos.Len()
+    var res []RestartInterval
+    cur := RestartInterval{Offset: base}
+    start := 0
+    for i := 0; i + 1 < len(sos.Image); i++ {
+        if sos.Image[i] != 0xff { continue; }
+        m := sos.Image[i+1]
+        if m < RST0 || m > RST7 { continue; }
+        cur.Data = sos.Image[start:i]
+        res = append(res, cur)
+        start = i + 2
+        cur = RestartInterval{Index: len(res), Marker: Byte(m), Offset: base + int64(start)}
+        i++
+    }
+    cur.Data = sos.Image[start:]
+    return append(res, cur)
+}
+
 type EoiEntry struct {
     Xff0 Byte   // +0
     ID Byte    // +1
@@ -758,19 +1139,19 @@ func (eoi *EoiEntry) IsValid() bool {
     return eoi.Xff0 == 255 && eoi.ID == EOI
 }
 // .Read(fd) fills the Entry and returns its "tail" (if Entry.Length exists)
-func (eoi *EoiEntry) Read(fd *os.File) error {
+func (eoi *EoiEntry) Read(fd SeekingReader) error {
     var e error
     if eoi.pos, e = Tell(fd); e != nil {
         return e
     }
     var tmp = make([]byte, 2)
-    if _, e = fd.Read(tmp); e != nil {
+    if _, e = io.ReadFull(fd, tmp); e != nil {
         return e
     }
     eoi.Xff0 = Byte(tmp[0])
     eoi.ID = Byte(tmp[1])
     if !eoi.IsValid() {
-        return fmt.Errorf("Invalid EOI entry %+v", eoi)
+        return badSegment(eoi.pos, eoi.ID, "invalid header")
     }
     return nil // no .Length, no "tail"
 }
@@ -0,0 +1,203 @@
+package jfif
+
+import (
+    "fmt"
+    "bytes"
+)
+import "testing"
+
+func TestRestartIntervals(t *testing.T) {
+    data := []byte{
+        0xff, SOI,
+        0xff, DRI, 0, 4, 0, 2,
+        0xff, SOS, 0, 8, 1, 1, 0, 0, 63, 0,
+        1, 2, 0xff, 0, 3, // stuffed byte does not split
+        0xff, RST0, 4, 5,
+        0xff, RST1,
+        0xff, RST3, 6,
+        0xff, EOI,
+    }
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    if ri := X.RestartInterval(); ri != 2 {
+        t.Errorf("Restart interval %d, expected 2", ri)
+    }
+    sos, ok := X.Entries[2].(*SosEntry)
+    if !ok {
+        t.Fatalf("No SOS in %v", X.Entries)
+    }
+    lst := sos.Intervals()
+    expected := []struct{ marker Byte; offset int64; data string; ok bool }{
+        {0, 18, "\x01\x02\xff\x00\x03", true},
+        {RST0, 25, "\x04\x05", true},
+        {RST1, 29, "", true},
+        {RST3, 31, "\x06", false},
+    }
+    if len(lst) != len(expected) {
+        t.Fatalf("Got %d intervals: %v", len(lst), lst)
+    }
+    for i, x := range expected {
+        ri := lst[i]
+        if ri.Index != i || ri.Marker != x.marker || ri.Offset != x.offset ||
+           string(ri.Data) != x.data || ri.InSequence() != x.ok {
+            t.Errorf("Interval %d: %+v, expected %+v", i, ri, x)
+        }
+    }
+}
+
+func TestProgressiveScans(t *testing.T) {
+    data := []byte{
+        0xff, SOI,
+        0xff, SOS, 0, 8, 1, 1, 0, 0, 0, 0x01, // DC first scan, Al=1
+        0xff, 0, 1, 0xff, RST0, 2,
+        0xff, DHT, 0, 20, 0x10, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x00,
+        0xff, SOS, 0, 8, 1, 1, 0x00, 1, 5, 0x21, // AC refinement, Ah=2 Al=1
+        3, 0xff, 0xff, // fill bytes before the marker
+        0xff, SOS, 0, 8, 1, 1, 0x00, 6, 63, 0x00,
+        4,
+        0xff, EOI,
+    }
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    var ids []Byte
+    for _, entry := range X.Entries { ids = append(ids, entry.GetId()); }
+    if len(ids) != 6 || ids[1] != SOS || ids[2] != DHT || ids[3] != SOS || ids[4] != SOS {
+        t.Fatalf("Got entries %v", X.Entries)
+    }
+    expected := []struct{ ss, se, ah, al Byte; image string }{
+        {0, 0, 0, 1, "\xff\x00\x01\xff\xd0\x02"},
+        {1, 5, 2, 1, "\x03\xff\xff"},
+        {6, 63, 0, 0, "\x04"},
+    }
+    for i, n := range []int{1, 3, 4} {
+        sos := X.Entries[n].(*SosEntry)
+        ss, se := sos.SpectralSelection()
+        ah, al := sos.SuccessiveApproximation()
+        x := expected[i]
+        if ss != x.ss || se != x.se || ah != x.ah || al != x.al || string(sos.Image) != x.image {
+            t.Errorf("Scan %d: %s %q, expected %+v", i, sos, sos.Image, x)
+        }
+    }
+}
+
+func TestHuffmanTables(t *testing.T) {
+    data := []byte{
+        0xff, SOI,
+        0xff, DHT, 0, 2 + 17 + 3 + 17 + 2,
+        0x00, 0, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 7, 8, 9,
+        0x11, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xa, 0xb,
+        0xff, EOI,
+    }
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    dht := X.Entries[1].(*DhtEntry)
+    if len(dht.Tables) != 2 {
+        t.Fatalf("Got tables %v", dht.Tables)
+    }
+    if ht := dht.Tables[1]; ht.Class != 1 || ht.ID != 1 || string(ht.Symbols) != "\x0a\x0b" {
+        t.Errorf("Bad table %v", ht)
+    }
+    codes, e := dht.Tables[0].Codes()
+    if e != nil {
+        t.Fatalf("Codes(): %v", e)
+    }
+    expected := []HuffmanCode{{7, 0, 2}, {8, 1, 2}, {9, 2, 2}}
+    if fmt.Sprint(codes) != fmt.Sprint(expected) {
+        t.Errorf("Got codes %v, expected %v", codes, expected)
+    }
+    var out bytes.Buffer
+    for _, entry := range X.Entries {
+        if e = entry.Write(&out); e != nil {
+            t.Fatalf("Write(): %v", e)
+        }
+    }
+    if !bytes.Equal(out.Bytes(), data) {
+        t.Errorf("Got % x\nexpected % x", out.Bytes(), data)
+    }
+}
+
+func TestFrameHeader(t *testing.T) {
+    data := []byte{
+        0xff, SOI,
+        0xff, SOF9, 0, 17, 8, 0x01, 0xe0, 0x02, 0x80, 3,
+        1, 0x22, 0, 2, 0x11, 1, 3, 0x11, 1,
+        0xff, EOI,
+    }
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    sof := X.Frame()
+    if sof == nil || sof.ID != SOF9 {
+        t.Fatalf("No SOF9 in %v", X.Entries)
+    }
+    if sof.Width != 640 || sof.Height != 480 {
+        t.Errorf("Got %dx%d, expected 640x480", sof.Width, sof.Height)
+    }
+    if c := sof.Components[0]; c.ID != 1 || c.H != 2 || c.V != 2 || c.Tq != 0 {
+        t.Errorf("Bad component %+v", c)
+    }
+    if s := sof.Subsampling(); s != "4:2:0" {
+        t.Errorf("Got subsampling %s", s)
+    }
+    if w, h := sof.McuSize(); w != 16 || h != 16 {
+        t.Errorf("Got MCU %dx%d", w, h)
+    }
+    var out bytes.Buffer
+    for _, entry := range X.Entries {
+        if e := entry.Write(&out); e != nil {
+            t.Fatalf("Write(): %v", e)
+        }
+    }
+    if !bytes.Equal(out.Bytes(), data) {
+        t.Errorf("Got % x\nexpected % x", out.Bytes(), data)
+    }
+}
+
+func TestSegmentLength(t *testing.T) {
+    data := []byte{
+        0xff, SOI,
+        0xff, APP1, 0, 5, 'a', 'b', 'c',
+        0xff, COM, 0, 4, 'h', 'i',
+        0xff, EOI,
+    }
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    X.Entries[1].(*AppnEntry).Data = []byte("abcdef")
+    X.Entries[2].(*CommentEntry).Data = nil
+    out, e := X.Bytes()
+    if e != nil {
+        t.Fatalf("Bytes(): %v", e)
+    }
+    expected := []byte{
+        0xff, SOI,
+        0xff, APP1, 0, 8, 'a', 'b', 'c', 'd', 'e', 'f',
+        0xff, COM, 0, 2,
+        0xff, EOI,
+    }
+    if !bytes.Equal(out, expected) {
+        t.Errorf("Got % x, expected % x", out, expected)
+    }
+    if n := X.Entries[1].Len(); n != 10 {
+        t.Errorf("APP1 length %d, expected 10", n)
+    }
+
+    X.Entries[1].(*AppnEntry).Data = make([]byte, 65534)
+    if _, e = X.Bytes(); e == nil {
+        t.Errorf("Oversized APP1 written")
+    }
+    X.Entries[1].(*AppnEntry).Data = make([]byte, 65533)
+    if _, e = X.Bytes(); e != nil {
+        t.Errorf("Largest APP1: %v", e)
+    }
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,49 @@
+package jfif
+
+import (
+    "io"
+    "fmt"
+    "errors"
+)
+
+var (
+    ErrTruncated = errors.New("unexpected end of data")
+    ErrUnknownMarker = errors.New("unknown marker")
+)
+
+// malformed JPEG stream; Err is ErrTruncated, ErrUnknownMarker or nil
+type FormatError struct {
+    Offset int64    // where the segment (or the byte in question) starts
+    Marker Byte     // 0 if there is no marker to blame
+    Reason string
+    Err error
+}
+
+func (fe *FormatError) Error() string {
+    if fe.Marker == 0 {
+        return fmt.Sprintf("at %d: %s", fe.Offset, fe.Reason)
+    }
+    name, ok := EntryName[fe.Marker]
+    if !ok { name = fmt.Sprintf("marker %02X", fe.Marker); }
+    return fmt.Sprintf("%s at %d: %s", name, fe.Offset, fe.Reason)
+}
+
+func (fe *FormatError) Unwrap() error { return fe.Err; }
+
+func badSegment(offset int64, marker Byte, format string, args ...any) error {
+    return &FormatError{Offset: offset, Marker: marker, Reason: fmt.Sprintf(format, args...)}
+}
+
+func truncated(offset int64, marker Byte, what string) error {
+    return &FormatError{Offset: offset, Marker: marker, Reason: "truncated " + what, Err: ErrTruncated}
+}
+
+// turns the end of data while reading a segment into ErrTruncated
+func readError(offset int64, marker Byte, e error) error {
+    if e == io.EOF || e == io.ErrUnexpectedEOF {
+        return truncated(offset, marker, "segment")
+    }
+    return e
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,52 @@
+package jfif
+
+import (
+    "bytes"
+    "errors"
+)
+import "testing"
+
+func TestFormatErrors(t *testing.T) {
+    sample := []byte{
+        0xff, SOI,
+        0xff, COM, 0, 4, 'h', 'i',
+        0xff, DQT, 0, 67, 0, 1, 2, 3,
+    }
+    tests := []struct{
+        name string
+        data []byte
+        offset int64
+        marker Byte
+        err error
+    }{
+        {"truncated", sample, 8, DQT, ErrTruncated},
+        {"unknown", append(append([]byte{}, sample[:8]...), 0xff, 0x02, 0, 2), 8, 0x02, ErrUnknownMarker},
+        {"garbage", append(append([]byte{}, sample[:8]...), 0x12, 0x34), 8, 0, nil},
+        {"short comment", sample[:7], 2, COM, ErrTruncated},
+        {"length 0", []byte{0xff, SOI, 0xff, APP1, 0, 0, 0xff, EOI}, 2, APP1, nil},
+        {"length 1", []byte{0xff, SOI, 0xff, DQT, 0, 1, 0xff, EOI}, 2, DQT, nil},
+    }
+    for _, test := range tests {
+        var X Jfif
+        e := X.Parse(test.data)
+        var fe *FormatError
+        if !errors.As(e, &fe) {
+            t.Errorf("%s: not a FormatError: %v", test.name, e)
+            continue
+        }
+        if fe.Offset != test.offset || fe.Marker != test.marker || fe.Err != test.err {
+            t.Errorf("%s: got %+v", test.name, fe)
+        }
+        if test.err != nil && !errors.Is(e, test.err) {
+            t.Errorf("%s: %v is not %v", test.name, e, test.err)
+        }
+    }
+
+    _, e := DecodeConfig(bytes.NewReader(sample[:11]))
+    var fe *FormatError
+    if !errors.As(e, &fe) || fe.Offset != 8 || fe.Marker != DQT || !errors.Is(e, ErrTruncated) {
+        t.Errorf("DecodeConfig(): %v", e)
+    }
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
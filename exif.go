@@ -0,0 +1,858 @@
+package jfif
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+    "unicode/utf16"
+    "encoding/binary"
+)
+
+// APP1 payload prefix of an Exif segment
+const ExifHeader = "Exif\x00\x00"
+
+// TIFF field types
+const (
+    TypeByte      = 1
+    TypeAscii     = 2
+    TypeShort     = 3
+    TypeLong      = 4
+    TypeRational  = 5
+    TypeSByte     = 6
+    TypeUndefined = 7
+    TypeSShort    = 8
+    TypeSLong     = 9
+    TypeSRational = 10
+    TypeFloat     = 11
+    TypeDouble    = 12
+)
+
+var TypeSize = map[Word]int{
+    TypeByte: 1,
+    TypeAscii: 1,
+    TypeShort: 2,
+    TypeLong: 4,
+    TypeRational: 8,
+    TypeSByte: 1,
+    TypeUndefined: 1,
+    TypeSShort: 2,
+    TypeSLong: 4,
+    TypeSRational: 8,
+    TypeFloat: 4,
+    TypeDouble: 8,
+}
+
+// IFDs of an Exif structure
+const (
+    IFD0 = iota // primary image
+    IFD1        // thumbnail
+    IFDExif     // Exif SubIFD
+    IFDGps      // GPS Info IFD
+    IFDInterop  // Interoperability IFD
+    ifdCount
+)
+
+var IfdName = map[int]string{
+    IFD0: "ifd0",
+    IFD1: "ifd1",
+    IFDExif: "exif",
+    IFDGps: "gps",
+    IFDInterop: "interop",
+}
+
+// tags that link IFDs together; they are maintained by the (de)serializer
+const (
+    TagExifIFDPointer    = 0x8769
+    TagGpsIFDPointer     = 0x8825
+    TagInteropIFDPointer = 0xa005
+    TagThumbnailOffset   = 0x0201
+    TagThumbnailLength   = 0x0202
+)
+
+// a raw IFD field, Value is kept in the byte order of the Exif structure
+type IfdField struct {
+    Tag Word
+    Type Word
+    Count Long
+    Value []byte
+}
+func (f *IfdField) String() string {
+    return fmt.Sprintf("<%04x:%d[%d] %v>", f.Tag, f.Type, f.Count, f.Value)
+}
+
+type Ifd struct {
+    Fields []IfdField
+    Dropped []IfdField // of unknown types, Value is the raw value/offset; not written back
+}
+
+func (ifd *Ifd) Get(tag Word) *IfdField {
+    if ifd == nil { return nil; }
+    for i := range ifd.Fields {
+        if ifd.Fields[i].Tag == tag {
+            return &ifd.Fields[i]
+        }
+    }
+    return nil
+}
+
+// .Set() replaces the field with the same tag or inserts it keeping
+// the fields sorted by tag as TIFF requires
+func (ifd *Ifd) Set(f IfdField) {
+    i := sort.Search(len(ifd.Fields), func(i int) bool { return ifd.Fields[i].Tag >= f.Tag; })
+    if i < len(ifd.Fields) && ifd.Fields[i].Tag == f.Tag {
+        ifd.Fields[i] = f
+        return
+    }
+    ifd.Fields = append(ifd.Fields, IfdField{})
+    copy(ifd.Fields[i+1:], ifd.Fields[i:])
+    ifd.Fields[i] = f
+}
+
+func (ifd *Ifd) Remove(tag Word) bool {
+    if ifd == nil { return false; }
+    for i := range ifd.Fields {
+        if ifd.Fields[i].Tag == tag {
+            ifd.Fields = append(ifd.Fields[:i], ifd.Fields[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+func (ifd *Ifd) IsEmpty() bool { return ifd == nil || len(ifd.Fields) == 0; }
+
+func (ifd *Ifd) clone() *Ifd {
+    var c Ifd
+    if ifd != nil {
+        c.Fields = append(c.Fields, ifd.Fields...)
+    }
+    return &c
+}
+
+// on-disk size of the IFD including out-of-line values
+func (ifd *Ifd) size() int {
+    size := 2 + 12 * len(ifd.Fields) + 4
+    for _, f := range ifd.Fields {
+        if len(f.Value) > 4 {
+            size += len(f.Value) + len(f.Value) % 2
+        }
+    }
+    return size
+}
+
+// .put() stores the IFD at `off` of `tiff` with its values right after it
+func (ifd *Ifd) put(tiff []byte, off int, next uint32, order binary.ByteOrder) {
+    order.PutUint16(tiff[off:], uint16(len(ifd.Fields)))
+    p := off + 2
+    data := p + 12 * len(ifd.Fields) + 4
+    for _, f := range ifd.Fields {
+        order.PutUint16(tiff[p:], uint16(f.Tag))
+        order.PutUint16(tiff[p+2:], uint16(f.Type))
+        order.PutUint32(tiff[p+4:], uint32(f.Count))
+        if len(f.Value) > 4 {
+            order.PutUint32(tiff[p+8:], uint32(data))
+            copy(tiff[data:], f.Value)
+            data += len(f.Value) + len(f.Value) % 2
+        } else {
+            copy(tiff[p+8:p+12], f.Value)
+        }
+        p += 12
+    }
+    order.PutUint32(tiff[p:], next)
+}
+
+// Exif structure of an APP1 segment
+type Exif struct {
+    Order binary.ByteOrder
+    Ifd [ifdCount]*Ifd
+    Thumbnail []byte
+}
+
+func NewExif() *Exif {
+    return &Exif{Order: binary.BigEndian}
+}
+
+func IsExif(data []byte) bool {
+    return len(data) >= len(ExifHeader) && string(data[:len(ExifHeader)]) == ExifHeader
+}
+
+// parses APP1 payload (including the "Exif\0\0" prefix)
+func ParseExif(data []byte) (*Exif, error) {
+    if !IsExif(data) {
+        return nil, fmt.Errorf("exif.ParseExif: no Exif header")
+    }
+    tiff := data[len(ExifHeader):]
+    if len(tiff) < 8 {
+        return nil, fmt.Errorf("exif.ParseExif: TIFF header truncated")
+    }
+    x := new(Exif)
+    switch string(tiff[:2]) {
+    case "II": x.Order = binary.LittleEndian
+    case "MM": x.Order = binary.BigEndian
+    default:
+        return nil, fmt.Errorf("exif.ParseExif: bad byte order %q", tiff[:2])
+    }
+    if x.Order.Uint16(tiff[2:]) != 42 {
+        return nil, fmt.Errorf("exif.ParseExif: bad TIFF magic %v", tiff[2:4])
+    }
+
+    seen := make(map[uint32]bool)
+    var e error
+    var next uint32
+    if x.Ifd[IFD0], next, e = x.readIfd(tiff, x.Order.Uint32(tiff[4:]), seen); e != nil {
+        return nil, fmt.Errorf("exif.ParseExif(%s): %w", IfdName[IFD0], e)
+    }
+    if next != 0 {
+        if x.Ifd[IFD1], _, e = x.readIfd(tiff, next, seen); e != nil {
+            return nil, fmt.Errorf("exif.ParseExif(%s): %w", IfdName[IFD1], e)
+        }
+    }
+    link := func(from, to int, tag Word) error {
+        f := x.Ifd[from].Get(tag)
+        if f == nil { return nil; }
+        off, ok := x.fieldLong(f)
+        x.Ifd[from].Remove(tag)
+        if !ok { return nil; }
+        if x.Ifd[to], _, e = x.readIfd(tiff, off, seen); e != nil {
+            return fmt.Errorf("exif.ParseExif(%s): %w", IfdName[to], e)
+        }
+        return nil
+    }
+    if e = link(IFD0, IFDExif, TagExifIFDPointer); e != nil { return nil, e; }
+    if e = link(IFD0, IFDGps, TagGpsIFDPointer); e != nil { return nil, e; }
+    if e = link(IFDExif, IFDInterop, TagInteropIFDPointer); e != nil { return nil, e; }
+
+    if ifd1 := x.Ifd[IFD1]; ifd1 != nil {
+        fo, fl := ifd1.Get(TagThumbnailOffset), ifd1.Get(TagThumbnailLength)
+        if fo != nil && fl != nil {
+            off, ok1 := x.fieldLong(fo)
+            size, ok2 := x.fieldLong(fl)
+            if ok1 && ok2 && uint64(off) + uint64(size) <= uint64(len(tiff)) {
+                x.Thumbnail = append([]byte(nil), tiff[off:off+size]...)
+            }
+            ifd1.Remove(TagThumbnailOffset)
+            ifd1.Remove(TagThumbnailLength)
+        }
+    }
+    return x, nil
+}
+
+func (x *Exif) fieldLong(f *IfdField) (uint32, bool) {
+    switch {
+    case f.Type == TypeLong && len(f.Value) >= 4: return x.Order.Uint32(f.Value), true
+    case f.Type == TypeShort && len(f.Value) >= 2: return uint32(x.Order.Uint16(f.Value)), true
+    }
+    return 0, false
+}
+
+func (x *Exif) readIfd(tiff []byte, off uint32, seen map[uint32]bool) (*Ifd, uint32, error) {
+    if seen[off] {
+        return nil, 0, fmt.Errorf("IFD loop at %d", off)
+    }
+    seen[off] = true
+    if uint64(off) + 2 > uint64(len(tiff)) {
+        return nil, 0, fmt.Errorf("IFD offset %d out of range", off)
+    }
+    n := int(x.Order.Uint16(tiff[off:]))
+    p := int(off) + 2
+    if p + 12 * n + 4 > len(tiff) {
+        return nil, 0, fmt.Errorf("IFD at %d truncated (%d entries)", off, n)
+    }
+    ifd := new(Ifd)
+    for i := 0; i < n; i, p = i + 1, p + 12 {
+        f := IfdField{
+            Tag: Word(x.Order.Uint16(tiff[p:])),
+            Type: Word(x.Order.Uint16(tiff[p+2:])),
+            Count: Long(x.Order.Uint32(tiff[p+4:])),
+        }
+        tsize, ok := TypeSize[f.Type]
+        if !ok { // unknown type: cannot be relocated, drop it
+            f.Value = append([]byte(nil), tiff[p+8:p+12]...)
+            ifd.Dropped = append(ifd.Dropped, f)
+            continue
+        }
+        size := uint64(tsize) * uint64(f.Count)
+        if size <= 4 {
+            f.Value = append([]byte(nil), tiff[p+8:p+8+int(size)]...)
+        } else {
+            voff := uint64(x.Order.Uint32(tiff[p+8:]))
+            if voff + size > uint64(len(tiff)) {
+                return nil, 0, fmt.Errorf("tag %04x value out of range", f.Tag)
+            }
+            f.Value = append([]byte(nil), tiff[voff:voff+size]...)
+        }
+        ifd.Fields = append(ifd.Fields, f)
+    }
+    return ifd, x.Order.Uint32(tiff[p:]), nil
+}
+
+// serializes the structure into APP1 payload (including the "Exif\0\0" prefix)
+func (x *Exif) Bytes() ([]byte, error) {
+    order := x.Order
+    if order == nil { order = binary.BigEndian; }
+    long := func(tag Word, v uint32) IfdField {
+        b := make([]byte, 4)
+        order.PutUint32(b, v)
+        return IfdField{Tag: tag, Type: TypeLong, Count: 1, Value: b}
+    }
+
+    var ifd [ifdCount]*Ifd
+    for i := range ifd {
+        ifd[i] = x.Ifd[i].clone()
+    }
+    hasInterop := !ifd[IFDInterop].IsEmpty()
+    hasExif := !ifd[IFDExif].IsEmpty() || hasInterop
+    hasGps := !ifd[IFDGps].IsEmpty()
+    hasIfd1 := !ifd[IFD1].IsEmpty() || x.Thumbnail != nil
+    // placeholders first, so the sizes are right
+    if hasExif { ifd[IFD0].Set(long(TagExifIFDPointer, 0)); }
+    if hasGps { ifd[IFD0].Set(long(TagGpsIFDPointer, 0)); }
+    if hasInterop { ifd[IFDExif].Set(long(TagInteropIFDPointer, 0)); }
+    if x.Thumbnail != nil {
+        ifd[IFD1].Set(long(TagThumbnailOffset, 0))
+        ifd[IFD1].Set(long(TagThumbnailLength, uint32(len(x.Thumbnail))))
+    }
+
+    var off [ifdCount]int
+    size := 8
+    for _, i := range []int{IFD0, IFDExif, IFDInterop, IFDGps, IFD1} {
+        if i == IFDExif && !hasExif { continue; }
+        if i == IFDInterop && !hasInterop { continue; }
+        if i == IFDGps && !hasGps { continue; }
+        if i == IFD1 && !hasIfd1 { continue; }
+        off[i] = size
+        size += ifd[i].size()
+    }
+    thumb := size
+    size += len(x.Thumbnail)
+    if len(ExifHeader) + size > 65535 - 2 {
+        return nil, fmt.Errorf("exif.Bytes: Exif structure too large (%d bytes)", size)
+    }
+
+    if hasExif { ifd[IFD0].Set(long(TagExifIFDPointer, uint32(off[IFDExif]))); }
+    if hasGps { ifd[IFD0].Set(long(TagGpsIFDPointer, uint32(off[IFDGps]))); }
+    if hasInterop { ifd[IFDExif].Set(long(TagInteropIFDPointer, uint32(off[IFDInterop]))); }
+    if x.Thumbnail != nil { ifd[IFD1].Set(long(TagThumbnailOffset, uint32(thumb))); }
+
+    tiff := make([]byte, size)
+    if order == binary.LittleEndian {
+        copy(tiff, "II")
+    } else {
+        copy(tiff, "MM")
+    }
+    order.PutUint16(tiff[2:], 42)
+    order.PutUint32(tiff[4:], uint32(off[IFD0]))
+    var next uint32
+    if hasIfd1 { next = uint32(off[IFD1]); }
+    ifd[IFD0].put(tiff, off[IFD0], next, order)
+    if hasExif { ifd[IFDExif].put(tiff, off[IFDExif], 0, order); }
+    if hasInterop { ifd[IFDInterop].put(tiff, off[IFDInterop], 0, order); }
+    if hasGps { ifd[IFDGps].put(tiff, off[IFDGps], 0, order); }
+    if hasIfd1 { ifd[IFD1].put(tiff, off[IFD1], 0, order); }
+    copy(tiff[thumb:], x.Thumbnail)
+
+    return append([]byte(ExifHeader), tiff...), nil
+}
+
+type TagInfo struct {
+    Ifd int
+    Tag Word
+    Name string
+    Type Word
+    Count int // 0 for variable length
+}
+
+var ExifTags = []TagInfo{
+    {IFD0, 0x0100, "ImageWidth", TypeLong, 1},
+    {IFD0, 0x0101, "ImageLength", TypeLong, 1},
+    {IFD0, 0x0102, "BitsPerSample", TypeShort, 3},
+    {IFD0, 0x0103, "Compression", TypeShort, 1},
+    {IFD0, 0x0106, "PhotometricInterpretation", TypeShort, 1},
+    {IFD0, 0x010e, "ImageDescription", TypeAscii, 0},
+    {IFD0, 0x010f, "Make", TypeAscii, 0},
+    {IFD0, 0x0110, "Model", TypeAscii, 0},
+    {IFD0, 0x0112, "Orientation", TypeShort, 1},
+    {IFD0, 0x0115, "SamplesPerPixel", TypeShort, 1},
+    {IFD0, 0x011a, "XResolution", TypeRational, 1},
+    {IFD0, 0x011b, "YResolution", TypeRational, 1},
+    {IFD0, 0x011c, "PlanarConfiguration", TypeShort, 1},
+    {IFD0, 0x0128, "ResolutionUnit", TypeShort, 1},
+    {IFD0, 0x012d, "TransferFunction", TypeShort, 768},
+    {IFD0, 0x0131, "Software", TypeAscii, 0},
+    {IFD0, 0x0132, "DateTime", TypeAscii, 20},
+    {IFD0, 0x013b, "Artist", TypeAscii, 0},
+    {IFD0, 0x013e, "WhitePoint", TypeRational, 2},
+    {IFD0, 0x013f, "PrimaryChromaticities", TypeRational, 6},
+    {IFD0, 0x0211, "YCbCrCoefficients", TypeRational, 3},
+    {IFD0, 0x0212, "YCbCrSubSampling", TypeShort, 2},
+    {IFD0, 0x0213, "YCbCrPositioning", TypeShort, 1},
+    {IFD0, 0x0214, "ReferenceBlackWhite", TypeRational, 6},
+    {IFD0, 0x8298, "Copyright", TypeAscii, 0},
+
+    {IFDExif, 0x829a, "ExposureTime", TypeRational, 1},
+    {IFDExif, 0x829d, "FNumber", TypeRational, 1},
+    {IFDExif, 0x8822, "ExposureProgram", TypeShort, 1},
+    {IFDExif, 0x8824, "SpectralSensitivity", TypeAscii, 0},
+    {IFDExif, 0x8827, "ISOSpeedRatings", TypeShort, 0},
+    {IFDExif, 0x8828, "OECF", TypeUndefined, 0},
+    {IFDExif, 0x8830, "SensitivityType", TypeShort, 1},
+    {IFDExif, 0x9000, "ExifVersion", TypeUndefined, 4},
+    {IFDExif, 0x9003, "DateTimeOriginal", TypeAscii, 20},
+    {IFDExif, 0x9004, "DateTimeDigitized", TypeAscii, 20},
+    {IFDExif, 0x9010, "OffsetTime", TypeAscii, 7},
+    {IFDExif, 0x9011, "OffsetTimeOriginal", TypeAscii, 7},
+    {IFDExif, 0x9012, "OffsetTimeDigitized", TypeAscii, 7},
+    {IFDExif, 0x9101, "ComponentsConfiguration", TypeUndefined, 4},
+    {IFDExif, 0x9102, "CompressedBitsPerPixel", TypeRational, 1},
+    {IFDExif, 0x9201, "ShutterSpeedValue", TypeSRational, 1},
+    {IFDExif, 0x9202, "ApertureValue", TypeRational, 1},
+    {IFDExif, 0x9203, "BrightnessValue", TypeSRational, 1},
+    {IFDExif, 0x9204, "ExposureBiasValue", TypeSRational, 1},
+    {IFDExif, 0x9205, "MaxApertureValue", TypeRational, 1},
+    {IFDExif, 0x9206, "SubjectDistance", TypeRational, 1},
+    {IFDExif, 0x9207, "MeteringMode", TypeShort, 1},
+    {IFDExif, 0x9208, "LightSource", TypeShort, 1},
+    {IFDExif, 0x9209, "Flash", TypeShort, 1},
+    {IFDExif, 0x920a, "FocalLength", TypeRational, 1},
+    {IFDExif, 0x9214, "SubjectArea", TypeShort, 0},
+    {IFDExif, 0x927c, "MakerNote", TypeUndefined, 0},
+    {IFDExif, 0x9286, "UserComment", TypeUndefined, 0},
+    {IFDExif, 0x9290, "SubSecTime", TypeAscii, 0},
+    {IFDExif, 0x9291, "SubSecTimeOriginal", TypeAscii, 0},
+    {IFDExif, 0x9292, "SubSecTimeDigitized", TypeAscii, 0},
+    {IFDExif, 0xa000, "FlashpixVersion", TypeUndefined, 4},
+    {IFDExif, 0xa001, "ColorSpace", TypeShort, 1},
+    {IFDExif, 0xa002, "PixelXDimension", TypeLong, 1},
+    {IFDExif, 0xa003, "PixelYDimension", TypeLong, 1},
+    {IFDExif, 0xa004, "RelatedSoundFile", TypeAscii, 13},
+    {IFDExif, 0xa20b, "FlashEnergy", TypeRational, 1},
+    {IFDExif, 0xa20e, "FocalPlaneXResolution", TypeRational, 1},
+    {IFDExif, 0xa20f, "FocalPlaneYResolution", TypeRational, 1},
+    {IFDExif, 0xa210, "FocalPlaneResolutionUnit", TypeShort, 1},
+    {IFDExif, 0xa214, "SubjectLocation", TypeShort, 2},
+    {IFDExif, 0xa215, "ExposureIndex", TypeRational, 1},
+    {IFDExif, 0xa217, "SensingMethod", TypeShort, 1},
+    {IFDExif, 0xa300, "FileSource", TypeUndefined, 1},
+    {IFDExif, 0xa301, "SceneType", TypeUndefined, 1},
+    {IFDExif, 0xa302, "CFAPattern", TypeUndefined, 0},
+    {IFDExif, 0xa401, "CustomRendered", TypeShort, 1},
+    {IFDExif, 0xa402, "ExposureMode", TypeShort, 1},
+    {IFDExif, 0xa403, "WhiteBalance", TypeShort, 1},
+    {IFDExif, 0xa404, "DigitalZoomRatio", TypeRational, 1},
+    {IFDExif, 0xa405, "FocalLengthIn35mmFilm", TypeShort, 1},
+    {IFDExif, 0xa406, "SceneCaptureType", TypeShort, 1},
+    {IFDExif, 0xa407, "GainControl", TypeShort, 1},
+    {IFDExif, 0xa408, "Contrast", TypeShort, 1},
+    {IFDExif, 0xa409, "Saturation", TypeShort, 1},
+    {IFDExif, 0xa40a, "Sharpness", TypeShort, 1},
+    {IFDExif, 0xa40c, "SubjectDistanceRange", TypeShort, 1},
+    {IFDExif, 0xa420, "ImageUniqueID", TypeAscii, 33},
+    {IFDExif, 0xa430, "CameraOwnerName", TypeAscii, 0},
+    {IFDExif, 0xa431, "BodySerialNumber", TypeAscii, 0},
+    {IFDExif, 0xa432, "LensSpecification", TypeRational, 4},
+    {IFDExif, 0xa433, "LensMake", TypeAscii, 0},
+    {IFDExif, 0xa434, "LensModel", TypeAscii, 0},
+    {IFDExif, 0xa435, "LensSerialNumber", TypeAscii, 0},
+    {IFDExif, 0xa500, "Gamma", TypeRational, 1},
+
+    {IFDGps, 0x0000, "GPSVersionID", TypeByte, 4},
+    {IFDGps, 0x0001, "GPSLatitudeRef", TypeAscii, 2},
+    {IFDGps, 0x0002, "GPSLatitude", TypeRational, 3},
+    {IFDGps, 0x0003, "GPSLongitudeRef", TypeAscii, 2},
+    {IFDGps, 0x0004, "GPSLongitude", TypeRational, 3},
+    {IFDGps, 0x0005, "GPSAltitudeRef", TypeByte, 1},
+    {IFDGps, 0x0006, "GPSAltitude", TypeRational, 1},
+    {IFDGps, 0x0007, "GPSTimeStamp", TypeRational, 3},
+    {IFDGps, 0x0008, "GPSSatellites", TypeAscii, 0},
+    {IFDGps, 0x0009, "GPSStatus", TypeAscii, 2},
+    {IFDGps, 0x000a, "GPSMeasureMode", TypeAscii, 2},
+    {IFDGps, 0x000b, "GPSDOP", TypeRational, 1},
+    {IFDGps, 0x000c, "GPSSpeedRef", TypeAscii, 2},
+    {IFDGps, 0x000d, "GPSSpeed", TypeRational, 1},
+    {IFDGps, 0x000e, "GPSTrackRef", TypeAscii, 2},
+    {IFDGps, 0x000f, "GPSTrack", TypeRational, 1},
+    {IFDGps, 0x0010, "GPSImgDirectionRef", TypeAscii, 2},
+    {IFDGps, 0x0011, "GPSImgDirection", TypeRational, 1},
+    {IFDGps, 0x0012, "GPSMapDatum", TypeAscii, 0},
+    {IFDGps, 0x0013, "GPSDestLatitudeRef", TypeAscii, 2},
+    {IFDGps, 0x0014, "GPSDestLatitude", TypeRational, 3},
+    {IFDGps, 0x0015, "GPSDestLongitudeRef", TypeAscii, 2},
+    {IFDGps, 0x0016, "GPSDestLongitude", TypeRational, 3},
+    {IFDGps, 0x0017, "GPSDestBearingRef", TypeAscii, 2},
+    {IFDGps, 0x0018, "GPSDestBearing", TypeRational, 1},
+    {IFDGps, 0x0019, "GPSDestDistanceRef", TypeAscii, 2},
+    {IFDGps, 0x001a, "GPSDestDistance", TypeRational, 1},
+    {IFDGps, 0x001b, "GPSProcessingMethod", TypeUndefined, 0},
+    {IFDGps, 0x001c, "GPSAreaInformation", TypeUndefined, 0},
+    {IFDGps, 0x001d, "GPSDateStamp", TypeAscii, 11},
+    {IFDGps, 0x001e, "GPSDifferential", TypeShort, 1},
+    {IFDGps, 0x001f, "GPSHPositioningError", TypeRational, 1},
+
+    {IFDInterop, 0x0001, "InteroperabilityIndex", TypeAscii, 4},
+    {IFDInterop, 0x0002, "InteroperabilityVersion", TypeUndefined, 4},
+    {IFDInterop, 0x1000, "RelatedImageFileFormat", TypeAscii, 0},
+    {IFDInterop, 0x1001, "RelatedImageWidth", TypeLong, 1},
+    {IFDInterop, 0x1002, "RelatedImageLength", TypeLong, 1},
+}
+
+// tags of type UNDEFINED carrying a character code prefix
+var commentTags = map[Word]bool{0x9286: true, 0x001b: true, 0x001c: true}
+
+// GPS coordinate tag -> its reference tag and the "negative" reference
+var gpsRefTags = map[Word]struct{ Ref Word; Pos, Neg string }{
+    0x0002: {0x0001, "N", "S"},
+    0x0004: {0x0003, "E", "W"},
+    0x0014: {0x0013, "N", "S"},
+    0x0016: {0x0015, "E", "W"},
+}
+
+// finds tag by name, optionally prefixed with an IFD name ("gps.latitude",
+// "exif.UserComment", "Orientation"); the case is ignored as well as the
+// "GPS" prefix of the GPS tag names
+func LookupTag(key string) (*TagInfo, bool) {
+    ifd := -1
+    name := strings.ToLower(key)
+    if dot := strings.IndexByte(name, '.'); dot >= 0 {
+        for i, n := range IfdName {
+            if n == name[:dot] { ifd = i; }
+        }
+        if ifd < 0 { return nil, false; }
+        name = name[dot+1:]
+    }
+    want := ifd
+    if ifd == IFD1 { want = IFD0; } // thumbnail IFD uses the primary image tags
+    for i := range ExifTags {
+        t := &ExifTags[i]
+        if want >= 0 && t.Ifd != want { continue; }
+        tn := strings.ToLower(t.Name)
+        if tn == name || (t.Ifd == IFDGps && tn == "gps" + name) {
+            if ifd == IFD1 {
+                t1 := *t
+                t1.Ifd = IFD1
+                return &t1, true
+            }
+            return t, true
+        }
+    }
+    return nil, false
+}
+
+// finds tag definition by IFD and tag number
+func TagByID(ifd int, tag Word) (*TagInfo, bool) {
+    want := ifd
+    if ifd == IFD1 { want = IFD0; }
+    for i := range ExifTags {
+        if ExifTags[i].Ifd == want && ExifTags[i].Tag == tag {
+            t := ExifTags[i]
+            t.Ifd = ifd
+            return &t, true
+        }
+    }
+    return nil, false
+}
+
+// sets tag named `key` (see LookupTag) to `value`, creating IFDs as needed
+func (x *Exif) SetValue(key string, value interface{}) error {
+    tag, ok := LookupTag(key)
+    if !ok {
+        return fmt.Errorf("exif.SetValue(%q): unknown tag", key)
+    }
+    if x.Order == nil { x.Order = binary.BigEndian; }
+
+    if ref, ok := gpsRefTags[tag.Tag]; ok && tag.Ifd == IFDGps {
+        deg, isScalar := toFloat(value)
+        if isScalar {
+            r := ref.Pos
+            if deg < 0 { r, deg = ref.Neg, -deg; }
+            x.setField(IFDGps, IfdField{Tag: ref.Ref, Type: TypeAscii, Count: 2, Value: []byte(r + "\x00")})
+            value = degreesToDMS(deg)
+        }
+    }
+    if tag.Ifd == IFDGps && tag.Tag == 0x0006 {
+        if alt, isScalar := toFloat(value); isScalar {
+            var r Byte
+            if alt < 0 { r, alt = 1, -alt; }
+            x.setField(IFDGps, IfdField{Tag: 0x0005, Type: TypeByte, Count: 1, Value: []byte{byte(r)}})
+            value = Rational{Num: int(math.Round(alt * 1000)), Den: 1000}
+        }
+    }
+
+    f, e := x.encodeValue(tag, value)
+    if e != nil {
+        return fmt.Errorf("exif.SetValue(%q): %w", key, e)
+    }
+    x.setField(tag.Ifd, f)
+    return nil
+}
+
+func (x *Exif) setField(ifd int, f IfdField) {
+    if x.Ifd[ifd] == nil {
+        x.Ifd[ifd] = new(Ifd)
+        switch ifd {
+        case IFDGps:
+            x.Ifd[ifd].Set(IfdField{Tag: 0x0000, Type: TypeByte, Count: 4, Value: []byte{2, 3, 0, 0}})
+        case IFDExif:
+            x.Ifd[ifd].Set(IfdField{Tag: 0x9000, Type: TypeUndefined, Count: 4, Value: []byte("0230")})
+        }
+    }
+    x.Ifd[ifd].Set(f)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+    switch v := v.(type) {
+    case Rational:
+        if v.Den == 0 { return 0, false; }
+        return float64(v.Num) / float64(v.Den), true
+    case float64: return v, true
+    case float32: return float64(v), true
+    case int: return float64(v), true
+    }
+    return 0, false
+}
+
+func degreesToDMS(deg float64) []Rational {
+    d := math.Floor(deg)
+    m := math.Floor((deg - d) * 60)
+    s := (deg - d - m / 60) * 3600
+    return []Rational{{int(d), 1}, {int(m), 1}, {int(math.Round(s * 100)), 100}}
+}
+
+// converts Go value into a field of the tag's type
+func (x *Exif) encodeValue(tag *TagInfo, value interface{}) (IfdField, error) {
+    f := IfdField{Tag: tag.Tag, Type: tag.Type}
+    var ints []int64
+    var rats []Rational
+    switch v := value.(type) {
+    case String:
+        return x.encodeString(tag, string(v))
+    case string:
+        return x.encodeString(tag, v)
+    case Undefined:
+        return x.encodeValue(tag, []byte(v))
+    case []byte:
+        if tag.Type != TypeUndefined && tag.Type != TypeByte {
+            return f, fmt.Errorf("cannot store bytes as type %d", tag.Type)
+        }
+        f.Value = append([]byte(nil), v...)
+        f.Count = Long(len(v))
+        return f, nil
+    case Byte: ints = []int64{int64(v)}
+    case Word: ints = []int64{int64(v)}
+    case Long: ints = []int64{int64(v)}
+    case int: ints = []int64{int64(v)}
+    case SByte: ints = []int64{int64(v)}
+    case SWord: ints = []int64{int64(v)}
+    case SLong: ints = []int64{int64(v)}
+    case []Byte: for _, i := range v { ints = append(ints, int64(i)); }
+    case []Word: for _, i := range v { ints = append(ints, int64(i)); }
+    case []Long: for _, i := range v { ints = append(ints, int64(i)); }
+    case []int: for _, i := range v { ints = append(ints, int64(i)); }
+    case []SLong: for _, i := range v { ints = append(ints, int64(i)); }
+    case Rational: rats = []Rational{v}
+    case []Rational: rats = v
+    case SRational: rats = []Rational{Rational(v)}
+    case []SRational: for _, r := range v { rats = append(rats, Rational(r)); }
+    case float64: rats = []Rational{{int(math.Round(v * 10000)), 10000}}
+    default:
+        return f, fmt.Errorf("unsupported value %#v", value)
+    }
+
+    n := len(ints) + len(rats)
+    if tag.Count > 0 && n != tag.Count {
+        return f, fmt.Errorf("expected %d values, got %d", tag.Count, n)
+    }
+    f.Count = Long(n)
+    size := TypeSize[tag.Type]
+    f.Value = make([]byte, size * n)
+    switch tag.Type {
+    case TypeByte, TypeShort, TypeLong:
+        if rats != nil { return f, fmt.Errorf("integer expected, got %v", rats); }
+        for i, v := range ints {
+            if v < 0 || v >= int64(1) << uint(8 * size) {
+                return f, fmt.Errorf("value %d out of range", v)
+            }
+            x.putInt(f.Value[i*size:], size, v)
+        }
+    case TypeSByte, TypeSShort, TypeSLong:
+        if rats != nil { return f, fmt.Errorf("integer expected, got %v", rats); }
+        for i, v := range ints {
+            if lim := int64(1) << uint(8 * size - 1); v < -lim || v >= lim {
+                return f, fmt.Errorf("value %d out of range", v)
+            }
+            x.putInt(f.Value[i*size:], size, v)
+        }
+    case TypeRational, TypeSRational:
+        for _, v := range ints { rats = append(rats, Rational{int(v), 1}); }
+        for i, r := range rats {
+            if tag.Type == TypeRational && (r.Num < 0 || r.Den < 0) {
+                return f, fmt.Errorf("negative value %v", r)
+            }
+            x.Order.PutUint32(f.Value[i*8:], uint32(r.Num))
+            x.Order.PutUint32(f.Value[i*8+4:], uint32(r.Den))
+        }
+    default:
+        return f, fmt.Errorf("cannot store %#v as type %d", value, tag.Type)
+    }
+    return f, nil
+}
+
+func (x *Exif) putInt(b []byte, size int, v int64) {
+    switch size {
+    case 1: b[0] = byte(v)
+    case 2: x.Order.PutUint16(b, uint16(v))
+    case 4: x.Order.PutUint32(b, uint32(v))
+    }
+}
+
+func (x *Exif) encodeString(tag *TagInfo, s string) (IfdField, error) {
+    f := IfdField{Tag: tag.Tag, Type: tag.Type}
+    switch tag.Type {
+    case TypeAscii:
+        f.Value = append([]byte(s), 0)
+    case TypeUndefined:
+        if commentTags[tag.Tag] {
+            f.Value = append([]byte("ASCII\x00\x00\x00"), s...)
+        } else {
+            f.Value = []byte(s)
+        }
+    default:
+        return f, fmt.Errorf("cannot store string as type %d", tag.Type)
+    }
+    if tag.Count > 0 && len(f.Value) > tag.Count {
+        return f, fmt.Errorf("string %q too long (max %d)", s, tag.Count)
+    }
+    f.Count = Long(len(f.Value))
+    return f, nil
+}
+
+// a decoded tag of an Exif structure
+type ExifValue struct {
+    Ifd int
+    Tag Word
+    Name string // empty for unknown tags
+    Value interface{}
+}
+func (v ExifValue) String() string {
+    name := v.Name
+    if name == "" { name = fmt.Sprintf("0x%04x", v.Tag); }
+    return fmt.Sprintf("%s.%s=%v", IfdName[v.Ifd], name, v.Value)
+}
+
+// decodes the field into typed value: Byte, String, Word, Long, Rational,
+// SByte, Undefined, SWord, SLong, SRational, float32 or float64 for fields
+// with a single value and slices of them otherwise (except for String and
+// Undefined); the character code prefix of the comment tags is stripped
+func (x *Exif) Decode(f *IfdField) interface{} {
+    o := x.Order
+    n := int(f.Count)
+    if size, ok := TypeSize[f.Type]; !ok || len(f.Value) < size * n {
+        return Undefined(f.Value)
+    }
+    single := n == 1
+    v := f.Value
+    switch f.Type {
+    case TypeAscii:
+        return String(strings.TrimRight(string(v), "\x00"))
+    case TypeUndefined:
+        if commentTags[f.Tag] && len(v) >= 8 {
+            return String(decodeComment(v, o))
+        }
+        return Undefined(v)
+    case TypeByte:
+        r := make([]Byte, n)
+        for i := range r { r[i] = Byte(v[i]); }
+        if single { return r[0]; }
+        return r
+    case TypeSByte:
+        r := make([]SByte, n)
+        for i := range r { r[i] = SByte(int8(v[i])); }
+        if single { return r[0]; }
+        return r
+    case TypeShort:
+        r := make([]Word, n)
+        for i := range r { r[i] = Word(o.Uint16(v[i*2:])); }
+        if single { return r[0]; }
+        return r
+    case TypeSShort:
+        r := make([]SWord, n)
+        for i := range r { r[i] = SWord(int16(o.Uint16(v[i*2:]))); }
+        if single { return r[0]; }
+        return r
+    case TypeLong:
+        r := make([]Long, n)
+        for i := range r { r[i] = Long(o.Uint32(v[i*4:])); }
+        if single { return r[0]; }
+        return r
+    case TypeSLong:
+        r := make([]SLong, n)
+        for i := range r { r[i] = SLong(int32(o.Uint32(v[i*4:]))); }
+        if single { return r[0]; }
+        return r
+    case TypeRational:
+        r := make([]Rational, n)
+        for i := range r { r[i] = Rational{int(o.Uint32(v[i*8:])), int(o.Uint32(v[i*8+4:]))}; }
+        if single { return r[0]; }
+        return r
+    case TypeSRational:
+        r := make([]SRational, n)
+        for i := range r { r[i] = SRational{int(int32(o.Uint32(v[i*8:]))), int(int32(o.Uint32(v[i*8+4:])))}; }
+        if single { return r[0]; }
+        return r
+    case TypeFloat:
+        r := make([]float32, n)
+        for i := range r { r[i] = math.Float32frombits(o.Uint32(v[i*4:])); }
+        if single { return r[0]; }
+        return r
+    case TypeDouble:
+        r := make([]float64, n)
+        for i := range r { r[i] = math.Float64frombits(o.Uint64(v[i*8:])); }
+        if single { return r[0]; }
+        return r
+    }
+    return Undefined(v)
+}
+
+func decodeComment(v []byte, order binary.ByteOrder) string {
+    code, text := string(v[:8]), v[8:]
+    switch code {
+    case "UNICODE\x00":
+        if len(text) >= 2 {
+            switch string(text[:2]) { // a BOM overrides the TIFF byte order
+            case "\xfe\xff": order, text = binary.BigEndian, text[2:]
+            case "\xff\xfe": order, text = binary.LittleEndian, text[2:]
+            }
+        }
+        u := make([]uint16, len(text) / 2)
+        for i := range u { u[i] = order.Uint16(text[i*2:]); }
+        return strings.TrimRight(string(utf16.Decode(u)), "\x00 ")
+    default: // ASCII, JIS or undefined
+        return strings.TrimRight(string(text), "\x00 ")
+    }
+}
+
+// every tag of the structure in IFD0, Exif, Interop, GPS, IFD1 order
+func (x *Exif) Values() []ExifValue {
+    var res []ExifValue
+    for _, i := range []int{IFD0, IFDExif, IFDInterop, IFDGps, IFD1} {
+        if x.Ifd[i] == nil { continue; }
+        for j := range x.Ifd[i].Fields {
+            f := &x.Ifd[i].Fields[j]
+            v := ExifValue{Ifd: i, Tag: f.Tag, Value: x.Decode(f)}
+            if t, ok := TagByID(i, f.Tag); ok { v.Name = t.Name; }
+            res = append(res, v)
+        }
+    }
+    return res
+}
+
+// returns decoded value of the tag named `key` (see LookupTag)
+func (x *Exif) Value(key string) (interface{}, bool) {
+    tag, ok := LookupTag(key)
+    if !ok { return nil, false; }
+    f := x.Ifd[tag.Ifd].Get(tag.Tag)
+    if f == nil { return nil, false; }
+    return x.Decode(f), true
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,101 @@
+package jfif
+
+import (
+    "fmt"
+    "bytes"
+    "strings"
+    "log/slog"
+    "encoding/binary"
+)
+import "testing"
+
+func TestExifByteOrders(t *testing.T) {
+    for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+        x := &Exif{Order: order}
+        for key, value := range map[string]interface{}{
+            "Orientation": Word(6),
+            "Make": String("go-jfif"),
+            "exif.UserComment": "comment",
+            "ExposureBiasValue": SRational{-1, 3},
+            "gps.longitude": -37.5,
+            "interop.InteroperabilityIndex": "R98",
+            "ifd1.XResolution": Rational{72, 1},
+        } {
+            if e := x.SetValue(key, value); e != nil {
+                t.Fatalf("SetValue(%q, %#v): %v", key, value, e)
+            }
+        }
+        x.Thumbnail = []byte{0xff, 0xd8, 0xff, 0xd9}
+        data, e := x.Bytes()
+        if e != nil {
+            t.Fatalf("Bytes(): %v", e)
+        }
+        y, e := ParseExif(data)
+        if e != nil {
+            t.Fatalf("ParseExif(): %v", e)
+        }
+        if y.Order != order {
+            t.Errorf("Byte order %v, expected %v", y.Order, order)
+        }
+        for key, expected := range map[string]interface{}{
+            "Orientation": Word(6),
+            "Make": String("go-jfif"),
+            "UserComment": String("comment"),
+            "ExposureBiasValue": SRational{-1, 3},
+            "GPSLongitudeRef": String("W"),
+            "gps.longitude": []Rational{{37, 1}, {30, 1}, {0, 100}},
+            "InteroperabilityIndex": String("R98"),
+            "ifd1.XResolution": Rational{72, 1},
+        } {
+            v, ok := y.Value(key)
+            if !ok {
+                t.Errorf("%v: no %q", order, key)
+                continue
+            }
+            if s1, s2 := fmt.Sprintf("%#v", v), fmt.Sprintf("%#v", expected); s1 != s2 {
+                t.Errorf("%v: %q is %s, expected %s", order, key, s1, s2)
+            }
+        }
+        if string(y.Thumbnail) != string(x.Thumbnail) {
+            t.Errorf("%v: thumbnail %v, expected %v", order, y.Thumbnail, x.Thumbnail)
+        }
+        if n := len(y.Values()); n != 10 {
+            t.Errorf("%v: got %d values: %v", order, n, y.Values())
+        }
+    }
+}
+
+func TestExifUnknownType(t *testing.T) {
+    data := []byte(ExifHeader + "MM\x00\x2a\x00\x00\x00\x08\x00\x02" +
+                   "\x01\x0f\x00\x02\x00\x00\x00\x02A\x00\x00\x00" + // Make "A"
+                   "\xc0\x00\x00\x63\x00\x00\x00\x01\x12\x34\x56\x78" + // type 99
+                   "\x00\x00\x00\x00")
+    x, e := ParseExif(data)
+    if e != nil {
+        t.Fatalf("ParseExif(): %v", e)
+    }
+    ifd := x.Ifd[IFD0]
+    if len(ifd.Fields) != 1 || len(ifd.Dropped) != 1 || ifd.Dropped[0].Tag != 0xc000 ||
+       ifd.Dropped[0].Type != 99 || string(ifd.Dropped[0].Value) != "\x12\x34\x56\x78" {
+        t.Errorf("Got fields %v, dropped %v", ifd.Fields, ifd.Dropped)
+    }
+
+    var X Jfif
+    if e = X.Load(testImages(t)[0]); e != nil {
+        t.Fatalf("Cannot load: %v", e)
+    }
+    app, e := X.exifEntry(true)
+    if e != nil {
+        t.Fatalf("exifEntry(): %v", e)
+    }
+    app.Data = data
+    var out bytes.Buffer
+    X.Logger = slog.New(slog.NewTextHandler(&out, nil))
+    if _, e = X.Exif(); e != nil {
+        t.Fatalf("Exif(): %v", e)
+    }
+    if !strings.Contains(out.String(), "level=WARN") || !strings.Contains(out.String(), "tag=c000") {
+        t.Errorf("No warning in log:\n%s", out.String())
+    }
+}
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,118 @@
+package jfif
+
+import (
+    "io"
+    "bytes"
+    "image"
+    "image/color"
+    "image/jpeg"
+)
+
+// the image package sees us as "jfif"; image/jpeg registers the same
+// magic and whichever is registered first wins in image.Decode(), so the
+// frames Jfif.Decode cannot handle are passed on to image/jpeg
+func init() {
+    image.RegisterFormat("jfif", "\xff\xd8", Decode, DecodeConfig)
+}
+
+// reads the whole stream and decodes it (see Jfif.Decode); progressive,
+// 12 bit and other frames the baseline decoder does not support go to
+// image/jpeg
+func Decode(r io.Reader) (image.Image, error) {
+    data, e := io.ReadAll(r)
+    if e != nil {
+        return nil, e
+    }
+    var x Jfif
+    if e = x.Parse(data); e != nil {
+        return nil, e
+    }
+    if sof, ok := x.Find(SOF0).(*SofEntry); !ok || sof.Precision != 8 {
+        if sof, ok = x.Find(SOF1).(*SofEntry); !ok || sof.Precision != 8 {
+            return jpeg.Decode(bytes.NewReader(data))
+        }
+    }
+    return x.Decode()
+}
+
+// reads the segments up to the frame header only, the scan data is never
+// touched
+func DecodeConfig(r io.Reader) (image.Config, error) {
+    var cfg image.Config
+    var hdr [4]byte
+    var pos int64
+    read := func(b []byte, at int64, marker Byte) error {
+        n, e := io.ReadFull(r, b)
+        pos += int64(n)
+        if e == io.EOF || e == io.ErrUnexpectedEOF {
+            return truncated(at, marker, "segment")
+        }
+        return e
+    }
+    if e := read(hdr[:2], pos, 0); e != nil {
+        return cfg, e
+    }
+    if hdr[0] != 0xff || hdr[1] != SOI {
+        return cfg, badSegment(0, 0, "no SOI")
+    }
+    for {
+        if e := read(hdr[:2], pos, 0); e != nil {
+            return cfg, e
+        }
+        if hdr[0] != 0xff {
+            return cfg, badSegment(pos - 2, 0, "marker expected, got %02X", hdr[0])
+        }
+        for hdr[1] == 0xff { // fill bytes
+            if e := read(hdr[1:2], pos, 0); e != nil {
+                return cfg, e
+            }
+        }
+        id, at := Byte(hdr[1]), pos - 2
+        if _, ok := EntryName[id]; !ok {
+            return cfg, &FormatError{Offset: at, Marker: id, Reason: "unknown marker", Err: ErrUnknownMarker}
+        }
+        if id == SOS || id == EOI {
+            return cfg, badSegment(at, id, "no frame header before")
+        }
+        if id == TEM || (id >= RST0 && id <= RST7) { // no length
+            continue
+        }
+        if e := read(hdr[2:4], at, id); e != nil {
+            return cfg, e
+        }
+        length := int64(GetWordBE(hdr[2:4]))
+        if length < 2 {
+            return cfg, badSegment(at, id, "bad length %d", length)
+        }
+        sof := SofEntry{Xff0: 255, ID: id}
+        if sof.IsValid() {
+            data := make([]byte, length - 2)
+            if e := read(data, at, id); e != nil {
+                return cfg, e
+            }
+            if len(data) < 6 {
+                return cfg, truncated(at, id, "frame header")
+            }
+            cfg.Height = int(GetWordBE(data[1:]))
+            cfg.Width = int(GetWordBE(data[3:]))
+            switch data[5] {
+            case 1: cfg.ColorModel = color.GrayModel
+            case 3: cfg.ColorModel = color.RGBAModel
+            case 4: cfg.ColorModel = color.CMYKModel
+            default:
+                return cfg, badSegment(at, id, "unsupported number of components %d", data[5])
+            }
+            return cfg, nil
+        }
+        n, e := io.CopyN(io.Discard, r, length - 2)
+        pos += n
+        if e == io.EOF {
+            return cfg, truncated(at, id, "segment")
+        }
+        if e != nil {
+            return cfg, e
+        }
+    }
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,157 @@
+package jfif
+
+import (
+    "fmt"
+    "bytes"
+    "strings"
+    "unicode/utf16"
+    "encoding/binary"
+)
+
+const IccHeader = SigICC + "\x00"
+
+// sequence number (1) + chunk count (1)
+const iccChunkHeader = 2
+
+// profile data per APP2 segment
+const iccChunkSize = 65535 - 2 - len(IccHeader) - iccChunkHeader
+
+func IsICC(data []byte) bool {
+    return bytes.HasPrefix(data, []byte(IccHeader)) && len(data) >= len(IccHeader) + iccChunkHeader
+}
+
+// the ICC profile header fields of interest, the signatures have
+// the padding spaces trimmed ("RGB", "XYZ", "mntr")
+type IccProfile struct {
+    Size uint32
+    CMM string
+    Version string // "4.3.0"
+    Class string
+    ColorSpace string
+    PCS string
+    Description string
+}
+
+func iccSignature(b []byte) string {
+    return strings.TrimRight(string(b[:4]), " \x00")
+}
+
+// parses the ICC profile header and its description tag
+func ParseICCProfile(data []byte) (*IccProfile, error) {
+    if len(data) < 132 || string(data[36:40]) != "acsp" {
+        return nil, fmt.Errorf("exif.ParseICCProfile: not an ICC profile")
+    }
+    be := binary.BigEndian
+    p := &IccProfile{
+        Size: be.Uint32(data),
+        CMM: iccSignature(data[4:]),
+        Version: fmt.Sprintf("%d.%d.%d", data[8], data[9] >> 4, data[9] & 15),
+        Class: iccSignature(data[12:]),
+        ColorSpace: iccSignature(data[16:]),
+        PCS: iccSignature(data[20:]),
+    }
+    count := int(be.Uint32(data[128:]))
+    if 132 + 12 * count > len(data) {
+        return nil, fmt.Errorf("exif.ParseICCProfile: truncated tag table")
+    }
+    for i := 0; i < count; i++ {
+        tag := data[132 + 12 * i:]
+        if string(tag[:4]) != "desc" { continue; }
+        off, size := int(be.Uint32(tag[4:])), int(be.Uint32(tag[8:]))
+        if off < 0 || size < 12 || off + size > len(data) || off + size < off {
+            return nil, fmt.Errorf("exif.ParseICCProfile: bad description tag")
+        }
+        p.Description = iccText(data[off:off + size])
+    }
+    return p, nil
+}
+
+// text of textDescriptionType (v2) or the first record of
+// multiLocalizedUnicodeType (v4) tag data
+func iccText(tag []byte) string {
+    be := binary.BigEndian
+    switch string(tag[:4]) {
+    case "desc":
+        n := int(be.Uint32(tag[8:]))
+        if n > len(tag) - 12 { n = len(tag) - 12; }
+        return strings.TrimRight(string(tag[12:12 + n]), "\x00")
+    case "mluc":
+        if len(tag) < 28 || be.Uint32(tag[8:]) == 0 { return ""; }
+        n, off := int(be.Uint32(tag[20:])), int(be.Uint32(tag[24:]))
+        if off + n > len(tag) || off + n < off { return ""; }
+        u := make([]uint16, n / 2)
+        for i := range u { u[i] = be.Uint16(tag[off + 2 * i:]); }
+        return string(utf16.Decode(u))
+    case "text":
+        return strings.TrimRight(string(tag[8:]), "\x00")
+    }
+    return ""
+}
+
+// the ICC profile reassembled from the APP2 chunks (in sequence number
+// order whatever the order of the segments is), nil if there is none
+func (x *Jfif) ICCProfile() ([]byte, error) {
+    var chunks [][]byte
+    for _, entry := range x.Entries {
+        app, ok := entry.(*AppnEntry)
+        if !ok || app.ID != APP2 || !IsICC(app.Data) { continue; }
+        seq, count := int(app.Data[len(IccHeader)]), int(app.Data[len(IccHeader) + 1])
+        if chunks == nil {
+            chunks = make([][]byte, count)
+        }
+        if count != len(chunks) {
+            return nil, fmt.Errorf("exif.ICCProfile(%q): chunk counts %d and %d", x.Path, len(chunks), count)
+        }
+        if seq < 1 || seq > count || chunks[seq - 1] != nil {
+            return nil, fmt.Errorf("exif.ICCProfile(%q): bad chunk number %d of %d", x.Path, seq, count)
+        }
+        chunks[seq - 1] = app.Data[len(IccHeader) + iccChunkHeader:]
+    }
+    if chunks == nil {
+        return nil, nil
+    }
+    var profile []byte
+    for i, chunk := range chunks {
+        if chunk == nil {
+            return nil, fmt.Errorf("exif.ICCProfile(%q): chunk %d of %d is missing", x.Path, i + 1, len(chunks))
+        }
+        profile = append(profile, chunk...)
+    }
+    return profile, nil
+}
+
+// replaces the ICC profile APP2 chunks with the `profile` ones put after
+// the APP0/APP1 segments; nil `profile` just removes them
+func (x *Jfif) SetICCProfile(profile []byte) error {
+    count := (len(profile) + iccChunkSize - 1) / iccChunkSize
+    if count > 255 {
+        return fmt.Errorf("exif.SetICCProfile(%q): profile of %d bytes is too large", x.Path, len(profile))
+    }
+    var entries []Entry
+    for _, entry := range x.Entries {
+        if app, ok := entry.(*AppnEntry); ok && app.ID == APP2 && IsICC(app.Data) { continue; }
+        entries = append(entries, entry)
+    }
+    if e := x.setEntries(entries); e != nil {
+        return fmt.Errorf("exif.SetICCProfile(%q): %w", x.Path, e)
+    }
+    at := 0
+    for i, entry := range x.Entries {
+        id := entry.GetId()
+        if id == SOI || id == APP0 || id == APP1 { at = i + 1; }
+        if id != SOI && id != COM && (id < APP0 || id > APPf) { break; }
+    }
+    for seq := 1; seq <= count; seq++ {
+        chunk := profile[(seq - 1) * iccChunkSize:]
+        if len(chunk) > iccChunkSize { chunk = chunk[:iccChunkSize]; }
+        data := append([]byte(IccHeader), byte(seq), byte(count))
+        app := &AppnEntry{Xff0: 255, ID: APP2, Data: append(data, chunk...)}
+        if e := x.insert(at, app); e != nil {
+            return fmt.Errorf("exif.SetICCProfile(%q): %w", x.Path, e)
+        }
+        at++
+    }
+    return nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
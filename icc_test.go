@@ -0,0 +1,77 @@
+package jfif
+
+import (
+    "bytes"
+    "encoding/binary"
+)
+import "testing"
+
+// a profile with a single description tag followed by `extra` bytes
+func testProfile(desc []byte, extra int) []byte {
+    be := binary.BigEndian
+    data := make([]byte, 132 + 12)
+    copy(data[4:], "lcms")
+    data[8], data[9] = 2, 0x10
+    copy(data[12:], "mntrRGB XYZ ")
+    copy(data[36:], "acsp")
+    be.PutUint32(data[128:], 1)
+    copy(data[132:], "desc")
+    be.PutUint32(data[136:], uint32(len(data)))
+    be.PutUint32(data[140:], uint32(len(desc)))
+    data = append(data, desc...)
+    data = append(data, make([]byte, extra)...)
+    be.PutUint32(data, uint32(len(data)))
+    return data
+}
+
+func TestICCProfile(t *testing.T) {
+    desc := []byte("desc\x00\x00\x00\x00\x00\x00\x00\x0bsRGB saved\x00")
+    profile := testProfile(desc, 150000)
+    for i := 200; i < len(profile); i++ { profile[i] = byte(i); }
+
+    var X Jfif
+    if e := X.Load(testImages(t)[0]); e != nil {
+        t.Fatalf("Cannot load: %v", e)
+    }
+    if p, e := X.ICCProfile(); p != nil || e != nil {
+        t.Fatalf("ICCProfile() of no profile: %v", e)
+    }
+    if e := X.SetICCProfile(profile); e != nil {
+        t.Fatalf("SetICCProfile(): %v", e)
+    }
+    if ids := segmentIds(&X); ids[:len("SOI APP0 APP2 APP2 APP2 DQT")] != "SOI APP0 APP2 APP2 APP2 DQT" {
+        t.Fatalf("Bad segments %q", ids)
+    }
+    X.Entries[2], X.Entries[4] = X.Entries[4], X.Entries[2] // out of order
+    got, e := X.ICCProfile()
+    if e != nil {
+        t.Fatalf("ICCProfile(): %v", e)
+    }
+    if !bytes.Equal(got, profile) {
+        t.Errorf("Got %d bytes of %d", len(got), len(profile))
+    }
+    hdr, e := ParseICCProfile(got)
+    if e != nil {
+        t.Fatalf("ParseICCProfile(): %v", e)
+    }
+    expected := IccProfile{Size: uint32(len(profile)), CMM: "lcms", Version: "2.1.0",
+                           Class: "mntr", ColorSpace: "RGB", PCS: "XYZ", Description: "sRGB saved"}
+    if *hdr != expected {
+        t.Errorf("Got %+v", hdr)
+    }
+
+    X.Entries = append(X.Entries[:3], X.Entries[4:]...)
+    if _, e = X.ICCProfile(); e == nil {
+        t.Errorf("Missing chunk is not noticed")
+    }
+    if e = X.SetICCProfile(nil); e != nil || len(X.FindAll(APP2)) != 0 {
+        t.Errorf("SetICCProfile(nil): %v", e)
+    }
+
+    mluc := []byte("mluc\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x0cenUS\x00\x00\x00\x04\x00\x00\x00\x1c\x00H\x00i")
+    if hdr, e = ParseICCProfile(testProfile(mluc, 0)); e != nil || hdr.Description != "Hi" {
+        t.Errorf("mluc description: %+v, %v", hdr, e)
+    }
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
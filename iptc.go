@@ -0,0 +1,244 @@
+package jfif
+
+import (
+    "fmt"
+    "strings"
+    "crypto/md5"
+    "unicode/utf8"
+    "encoding/binary"
+)
+
+// IPTC-IIM dataset (record:dataset and its value)
+type IptcDataset struct {
+    Record, Dataset Byte
+    Data []byte
+}
+
+type IptcTagInfo struct {
+    Record, Dataset Byte
+    Name string
+    Repeatable bool
+}
+
+// the datasets of the envelope (1) and application (2) records of interest
+var IptcTags = []IptcTagInfo{
+    {1, 90, "CodedCharacterSet", false},
+    {2, 0, "RecordVersion", false},
+    {2, 5, "ObjectName", false},
+    {2, 10, "Urgency", false},
+    {2, 15, "Category", false},
+    {2, 20, "SupplementalCategories", true},
+    {2, 25, "Keywords", true},
+    {2, 40, "SpecialInstructions", false},
+    {2, 55, "DateCreated", false},
+    {2, 60, "TimeCreated", false},
+    {2, 80, "Byline", true},
+    {2, 85, "BylineTitle", true},
+    {2, 90, "City", false},
+    {2, 92, "SubLocation", false},
+    {2, 95, "ProvinceState", false},
+    {2, 100, "CountryCode", false},
+    {2, 101, "Country", false},
+    {2, 103, "OriginalTransmissionReference", false},
+    {2, 105, "Headline", false},
+    {2, 110, "Credit", false},
+    {2, 115, "Source", false},
+    {2, 116, "CopyrightNotice", false},
+    {2, 118, "Contact", true},
+    {2, 120, "Caption", false},
+    {2, 122, "CaptionWriter", true},
+}
+
+// ESC % G, the CodedCharacterSet value for UTF-8
+const iptcUTF8 = "\x1b%G"
+
+// finds dataset by name, the case is ignored
+func LookupIptcTag(name string) (*IptcTagInfo, bool) {
+    for i := range IptcTags {
+        if strings.EqualFold(IptcTags[i].Name, name) {
+            return &IptcTags[i], true
+        }
+    }
+    return nil, false
+}
+
+type Iptc []IptcDataset
+
+// parses IPTC-IIM datasets (0x0404 image resource data)
+func ParseIPTC(data []byte) (Iptc, error) {
+    var res Iptc
+    for off := 0; off < len(data); {
+        if data[off] != 0x1c {
+            if strings.Trim(string(data[off:]), "\x00") == "" { break; } // padding
+            return nil, fmt.Errorf("exif.ParseIPTC: no tag marker at %d", off)
+        }
+        if off + 5 > len(data) {
+            return nil, fmt.Errorf("exif.ParseIPTC: truncated dataset at %d", off)
+        }
+        ds := IptcDataset{Record: Byte(data[off + 1]), Dataset: Byte(data[off + 2])}
+        size, p := int(binary.BigEndian.Uint16(data[off + 3:])), off + 5
+        if size & 0x8000 != 0 { // extended dataset: the size of the size follows
+            n := size & 0x7fff
+            if n > 4 || p + n > len(data) {
+                return nil, fmt.Errorf("exif.ParseIPTC: bad extended dataset at %d", off)
+            }
+            size = 0
+            for _, b := range data[p:p + n] { size = size << 8 | int(b); }
+            p += n
+        }
+        if p + size > len(data) {
+            return nil, fmt.Errorf("exif.ParseIPTC: dataset %d:%d at %d: %d bytes of %d",
+                                   ds.Record, ds.Dataset, off, len(data) - p, size)
+        }
+        ds.Data = data[p:p + size]
+        res = append(res, ds)
+        off = p + size
+    }
+    return res, nil
+}
+
+// serializes the datasets
+func (x Iptc) Bytes() []byte {
+    var data []byte
+    for _, ds := range x {
+        data = append(data, 0x1c, byte(ds.Record), byte(ds.Dataset))
+        if len(ds.Data) < 0x8000 {
+            data = binary.BigEndian.AppendUint16(data, uint16(len(ds.Data)))
+        } else {
+            data = binary.BigEndian.AppendUint16(data, 0x8004)
+            data = binary.BigEndian.AppendUint32(data, uint32(len(ds.Data)))
+        }
+        data = append(data, ds.Data...)
+    }
+    return data
+}
+
+func (x Iptc) utf8() bool {
+    for _, ds := range x {
+        if ds.Record == 1 && ds.Dataset == 90 { return string(ds.Data) == iptcUTF8; }
+    }
+    return false
+}
+
+// values of the dataset named `name` (see IptcTags); the text that is
+// neither declared nor valid UTF-8 is taken as Latin-1
+func (x Iptc) Get(name string) []string {
+    tag, ok := LookupIptcTag(name)
+    if !ok {
+        return nil
+    }
+    isUTF8 := x.utf8()
+    var res []string
+    for _, ds := range x {
+        if ds.Record != tag.Record || ds.Dataset != tag.Dataset { continue; }
+        if isUTF8 || utf8.Valid(ds.Data) {
+            res = append(res, string(ds.Data))
+        } else {
+            r := make([]rune, len(ds.Data))
+            for i, b := range ds.Data { r[i] = rune(b); }
+            res = append(res, string(r))
+        }
+    }
+    return res
+}
+
+// replaces the dataset named `name` (see IptcTags) with the `values`, none
+// removes it; the texts are stored in UTF-8 and declared so, the Latin-1
+// ones already there are converted then
+func (x *Iptc) Set(name string, values ...string) error {
+    tag, ok := LookupIptcTag(name)
+    if !ok {
+        return fmt.Errorf("exif.Iptc.Set(%q): unknown dataset", name)
+    }
+    if len(values) > 1 && !tag.Repeatable {
+        return fmt.Errorf("exif.Iptc.Set(%q): not repeatable", name)
+    }
+    declare := tag.Record == 1 && tag.Dataset == 90
+    if len(values) > 0 && !x.utf8() && (!declare || values[0] == iptcUTF8) {
+        x.recode()
+        if !declare { x.put(1, 90, []string{iptcUTF8}); }
+    }
+    if len(values) > 0 && tag.Record == 2 && !x.has(2, 0) {
+        x.put(2, 0, []string{"\x00\x04"})
+    }
+    x.put(tag.Record, tag.Dataset, values)
+    return nil
+}
+
+// converts the application record texts that are not valid UTF-8 from
+// Latin-1 (as Get reads them) before the record is declared UTF-8
+func (x Iptc) recode() {
+    for i, ds := range x {
+        if ds.Record != 2 || ds.Dataset == 0 || utf8.Valid(ds.Data) { continue; }
+        r := make([]rune, len(ds.Data))
+        for j, b := range ds.Data { r[j] = rune(b); }
+        x[i].Data = []byte(string(r))
+    }
+}
+
+func (x Iptc) has(record, dataset Byte) bool {
+    for _, ds := range x {
+        if ds.Record == record && ds.Dataset == dataset { return true; }
+    }
+    return false
+}
+
+// replaces the datasets keeping them ordered by record and dataset numbers
+func (x *Iptc) put(record, dataset Byte, values []string) {
+    var res Iptc
+    at := -1
+    for _, ds := range *x {
+        if ds.Record == record && ds.Dataset == dataset { continue; }
+        if at < 0 && (ds.Record > record || ds.Record == record && ds.Dataset > dataset) { at = len(res); }
+        res = append(res, ds)
+    }
+    if at < 0 { at = len(res); }
+    var add Iptc
+    for _, v := range values {
+        add = append(add, IptcDataset{Record: record, Dataset: dataset, Data: []byte(v)})
+    }
+    *x = append(res[:at], append(add, res[at:]...)...)
+}
+
+// parses IPTC-IIM of the Photoshop APP13 segment, nil if there is none
+func (x *Jfif) IPTC() (Iptc, error) {
+    res, e := x.ImageResources()
+    if e != nil {
+        return nil, e
+    }
+    for _, r := range res {
+        if r.ID != ResourceIPTC { continue; }
+        iptc, e := ParseIPTC(r.Data)
+        if e != nil {
+            return nil, fmt.Errorf("exif.IPTC(%q): %w", x.Path, e)
+        }
+        return iptc, nil
+    }
+    return nil, nil
+}
+
+// stores IPTC-IIM into the Photoshop APP13 segment keeping the other image
+// resources and updating the IPTC digest one if it is there
+func (x *Jfif) SetIPTC(iptc Iptc) error {
+    res, e := x.ImageResources()
+    if e != nil {
+        return e
+    }
+    data := iptc.Bytes()
+    digest := md5.Sum(data)
+    found := false
+    for i := range res {
+        switch res[i].ID {
+        case ResourceIPTC:
+            res[i].Data, found = data, true
+        case ResourceIPTCDigest:
+            res[i].Data = digest[:]
+        }
+    }
+    if !found {
+        res = append(res, ImageResource{Type: "8BIM", ID: ResourceIPTC, Data: data})
+    }
+    return x.SetImageResources(res)
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
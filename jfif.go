@@ -4,6 +4,11 @@ import (
     "os"
     "io"
     "fmt"
+    "sort"
+    "bufio"
+    "bytes"
+    "log/slog"
+    "path/filepath"
 )
 
 type JfifData map[string]interface{}
@@ -15,6 +20,11 @@ type Word uint16
 type Long uint32
 type String string
 type Rational struct { Num, Den int }
+type SByte int8
+type SWord int16
+type SLong int32
+type SRational struct { Num, Den int }
+type Undefined []byte
 
 func loadSize(fd *os.File, size size_t) ([]byte, error) {
     var buffer = make([]byte, size)
@@ -40,6 +50,28 @@ type Jfif struct {
     Path string
     Entries []Entry
     NoDataLeft bool
+    Logger *slog.Logger // debug events and warnings go here, nil keeps the package silent
+}
+
+func (x *Jfif) debug(msg string, args ...any) {
+    if x.Logger != nil {
+        x.Logger.Debug(msg, args...)
+    }
+}
+
+func (x *Jfif) warn(msg string, args ...any) {
+    if x.Logger != nil {
+        x.Logger.Warn(msg, args...)
+    }
+}
+
+// per-segment attributes for the logger
+func entryAttrs(entry Entry) []any {
+    return []any{
+        slog.Int64("offset", entry.Pos()),
+        slog.String("marker", EntryName[entry.GetId()]),
+        slog.Int64("length", entry.Len()),
+    }
 }
 
 func (x *Jfif) SectionAt(pos int64) Entry {
@@ -52,48 +84,54 @@ func (x *Jfif) SectionAt(pos int64) Entry {
 }
 
 func (x *Jfif) Load(path string) error {
-    fmt.Printf("exif.Load(%#v)\n", path)
+    x.debug("exif.Load", "path", path)
     x.Path = path
 
     fd, e := os.Open(path)
     if e != nil {
-        return fmt.Errorf("exif.Load.Open(%s): %v", path, e)
+        return fmt.Errorf("exif.Load.Open(%s): %w", path, e)
     }
     defer fd.Close()
 
+    return x.LoadFrom(fd)
+}
+
+// parses JFIF stream from its very beginning
+func (x *Jfif) LoadFrom(fd io.ReadSeeker) error {
     size, e := fd.Seek(0, 2)
     if e != nil {
-        return fmt.Errorf("exif.Load.SeekEnd(%s): %v", path, e)
+        return fmt.Errorf("exif.Load.SeekEnd(%s): %w", x.Path, e)
     }
 
     _, e = fd.Seek(0, 0)
     if e != nil {
-        return fmt.Errorf("exif.Load.SeekStart(%s): %v", path, e)
+        return fmt.Errorf("exif.Load.SeekStart(%s): %w", x.Path, e)
     }
 
-    fmt.Printf("exif.Load(%q): %v bytes\n", x.Path, size)
+    x.debug("exif.Load", "path", x.Path, "size", size)
 
     var tmp anEntry
 
+    x.Entries = nil
     for {
         entry, err := tmp.ReadEntry(fd)
         if err != nil {
-            return fmt.Errorf("exif.Load.entry(%s): %v", path, err)
+            return fmt.Errorf("exif.Load.entry(%s): %w", x.Path, err)
         }
-        fmt.Printf("%s\n", entry)
+        x.debug("exif.Load: segment", entryAttrs(entry)...)
         x.Entries = append(x.Entries, entry)
         if entry.GetId() == EOI { break; }
     }
     here, e := Tell(fd)
     if e != nil {
-        return fmt.Errorf("exif.Load(%q): %v", path, e)
+        return fmt.Errorf("exif.Load(%q): %w", x.Path, e)
     }
     x.NoDataLeft = here == size
     if x.NoDataLeft {
-        fmt.Printf("File data exhausted.\n")
+        x.debug("exif.Load: file data exhausted", "path", x.Path)
     } else {
-        fmt.Printf("File data dangle: expecting %v, got %v, delta %v\n",
-                   size, here, size - here)
+        x.debug("exif.Load: file data dangle", "path", x.Path,
+                "size", size, "offset", here, "delta", size - here)
     }
 
     /*
@@ -105,26 +143,258 @@ func (x *Jfif) Load(path string) error {
     return nil
 }
 
-func (x *Jfif) SaveTo(path string) error {
-    fmt.Printf("exif.SaveTo(%#v)\n", path)
-    fd, e := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
-    if e != nil { return e; }
-    defer fd.Close()
-    for _, entry := range x.Entries {
-        fmt.Printf("\tsaving %s\n", entry)
-        entry.Write(fd)
+// parses in-memory JFIF image
+func (x *Jfif) Parse(data []byte) error {
+    return x.LoadFrom(bytes.NewReader(data))
+}
+
+// writes the image to a temporary file next to `path`, syncs it and
+// renames it over `path` keeping the mode of the file being replaced;
+// a symlink `path` is followed, the directory is synced after the rename
+func (x *Jfif) SaveTo(path string) (err error) {
+    x.debug("exif.SaveTo", "path", path)
+    target := path
+    if resolved, e := filepath.EvalSymlinks(path); e == nil {
+        target = resolved
+    }
+    mode := os.FileMode(0644)
+    if fi, e := os.Stat(target); e == nil {
+        mode = fi.Mode().Perm()
+    }
+    fd, e := os.CreateTemp(filepath.Dir(target), "." + filepath.Base(target) + ".*")
+    if e != nil {
+        return fmt.Errorf("exif.SaveTo(%q): %w", path, e)
+    }
+    defer func() {
+        if err != nil {
+            fd.Close()
+            os.Remove(fd.Name())
+        }
+    }()
+    out := bufio.NewWriter(fd)
+    if _, e = x.WriteTo(out); e != nil {
+        return fmt.Errorf("exif.SaveTo(%q): %w", path, e)
+    }
+    if e = out.Flush(); e != nil {
+        return fmt.Errorf("exif.SaveTo(%q): %w", path, e)
+    }
+    if e = fd.Chmod(mode); e != nil {
+        return fmt.Errorf("exif.SaveTo(%q): %w", path, e)
+    }
+    if e = fd.Sync(); e != nil {
+        return fmt.Errorf("exif.SaveTo(%q): %w", path, e)
+    }
+    if e = fd.Close(); e != nil {
+        return fmt.Errorf("exif.SaveTo(%q): %w", path, e)
+    }
+    if e = os.Rename(fd.Name(), target); e != nil {
+        return fmt.Errorf("exif.SaveTo(%q): %w", path, e)
+    }
+    dir, e := os.Open(filepath.Dir(target))
+    if e == nil {
+        e = dir.Sync()
+        dir.Close()
+    }
+    if e != nil {
+        return fmt.Errorf("exif.SaveTo(%q): %w", path, e)
     }
     return nil
 }
 
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+func (cw *countingWriter) Write(b []byte) (int, error) {
+    n, e := cw.w.Write(b)
+    cw.n += int64(n)
+    return n, e
+}
+
+// serializes every segment into `w` (io.WriterTo)
+func (x *Jfif) WriteTo(w io.Writer) (int64, error) {
+    out := &countingWriter{w: w}
+    for _, entry := range x.Entries {
+        x.debug("exif.WriteTo: segment", entryAttrs(entry)...)
+        if e := entry.Write(out); e != nil {
+            return out.n, fmt.Errorf("%s at %d: %w", EntryName[entry.GetId()], out.n, e)
+        }
+    }
+    return out.n, nil
+}
+
+// the serialized image
+func (x *Jfif) Bytes() ([]byte, error) {
+    var out bytes.Buffer
+    if _, e := x.WriteTo(&out); e != nil {
+        return nil, e
+    }
+    return out.Bytes(), nil
+}
+
+// writes the image back to the file it was loaded from (see SaveTo)
 func (x *Jfif) Save() error {
-    fmt.Printf("exif.Save() -> %#v\n", x.Path)
-    // return x.SaveTo(x.Path)
-    return nil
+    x.debug("exif.Save", "path", x.Path)
+    if x.Path == "" {
+        return fmt.Errorf("exif.Save: no path")
+    }
+    return x.SaveTo(x.Path)
 }
 
+// finds the Exif APP1 segment; with `create` a new one is inserted
+// right after SOI/APP0 if there is none
+func (x *Jfif) exifEntry(create bool) (*AppnEntry, error) {
+    for _, entry := range x.Entries {
+        if app, ok := entry.(*AppnEntry); ok && app.ID == APP1 && IsExif(app.Data) {
+            return app, nil
+        }
+    }
+    if !create {
+        return nil, nil
+    }
+    data, e := NewExif().Bytes()
+    if e != nil {
+        return nil, e
+    }
+    app := &AppnEntry{Xff0: 255, ID: APP1, Length: Word(len(data) + 2), Data: data}
+    at := 0
+    for at < len(x.Entries) {
+        if id := x.Entries[at].GetId(); id != SOI && id != APP0 { break; }
+        at++
+    }
+    if e = x.insert(at, app); e != nil {
+        return nil, e
+    }
+    return app, nil
+}
+
+// parses the Exif APP1 segment, returns nil if there is none; the fields
+// of unknown types are logged as they are lost on SetExif
+func (x *Jfif) Exif() (*Exif, error) {
+    app, _ := x.exifEntry(false)
+    if app == nil {
+        return nil, nil
+    }
+    exif, e := ParseExif(app.Data)
+    if e != nil {
+        return nil, fmt.Errorf("exif.Exif(%q): %w", x.Path, e)
+    }
+    for i, ifd := range exif.Ifd {
+        if ifd == nil { continue; }
+        for _, f := range ifd.Dropped {
+            x.warn("exif.Exif: field of unknown type is dropped", "path", x.Path,
+                   "ifd", IfdName[i], "tag", fmt.Sprintf("%04x", f.Tag), "type", f.Type)
+        }
+    }
+    return exif, nil
+}
+
+// sets Exif tags named by `xif` keys (see LookupTag) in the APP1 segment
 func (x *Jfif) Inject(xif JfifData) error {
-    fmt.Printf("exif.Inject(%q, %#v)\n", x.Path, xif)
+    x.debug("exif.Inject", "path", x.Path, "tags", len(xif))
+    exif, e := x.Exif()
+    if e != nil {
+        return fmt.Errorf("exif.Inject(%q): %w", x.Path, e)
+    }
+    if exif == nil {
+        exif = NewExif()
+    }
+    keys := make([]string, 0, len(xif))
+    for key := range xif { keys = append(keys, key); }
+    sort.Strings(keys)
+    for _, key := range keys {
+        if e = exif.SetValue(key, xif[key]); e != nil {
+            return fmt.Errorf("exif.Inject(%q): %w", x.Path, e)
+        }
+    }
+    return x.SetExif(exif)
+}
+
+// stores the Exif structure into the APP1 segment, inserting one if needed
+func (x *Jfif) SetExif(exif *Exif) error {
+    data, e := exif.Bytes()
+    if e != nil {
+        return fmt.Errorf("exif.SetExif(%q): %w", x.Path, e)
+    }
+    app, e := x.exifEntry(true)
+    if e != nil {
+        return fmt.Errorf("exif.SetExif(%q): %w", x.Path, e)
+    }
+    app.Data = data
+    return nil
+}
+
+// the frame header (SOFn), nil if there is none
+func (x *Jfif) Frame() *SofEntry {
+    for _, entry := range x.Entries {
+        if sof, ok := entry.(*SofEntry); ok {
+            return sof
+        }
+    }
+    return nil
+}
+
+// MCUs per restart interval as defined by DRI, 0 if there is none
+func (x *Jfif) RestartInterval() Word {
+    for _, entry := range x.Entries {
+        if dri, ok := entry.(*DriEntry); ok {
+            return dri.Interval
+        }
+    }
+    return 0
+}
+
+// texts of all COM segments
+func (x *Jfif) Comments() []string {
+    var res []string
+    for _, entry := range x.Entries {
+        if com, ok := entry.(*CommentEntry); ok {
+            res = append(res, com.Text())
+        }
+    }
+    return res
+}
+
+// adds a COM segment after the last of SOI/APPn/COM segments
+func (x *Jfif) AddComment(text string) error {
+    com, e := NewCommentEntry(text)
+    if e != nil {
+        return fmt.Errorf("exif.AddComment(%q): %w", x.Path, e)
+    }
+    at := 0
+    for i, entry := range x.Entries {
+        id := entry.GetId()
+        if id == SOI || id == COM || (id >= APP0 && id <= APPf) {
+            at = i + 1
+        } else {
+            break
+        }
+    }
+    if e = x.insert(at, com); e != nil {
+        return fmt.Errorf("exif.AddComment(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+// replaces all COM segments with the `texts`
+func (x *Jfif) SetComments(texts []string) error {
+    for _, text := range texts { // do not touch anything if some is bad
+        if _, e := NewCommentEntry(text); e != nil {
+            return fmt.Errorf("exif.SetComments(%q): %w", x.Path, e)
+        }
+    }
+    entries := x.Entries[:0]
+    for _, entry := range x.Entries {
+        if entry.GetId() != COM {
+            entries = append(entries, entry)
+        }
+    }
+    x.Entries = entries
+    for _, text := range texts {
+        if e := x.AddComment(text); e != nil {
+            return e
+        }
+    }
     return nil
 }
 /* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -3,7 +3,11 @@ package jfif
 import (
     "os"
     "path"
+    "bytes"
+    "strings"
+    "log/slog"
     "io/ioutil"
+    "path/filepath"
 )
 import "testing"
 
@@ -35,11 +39,8 @@ func compare(t *testing.T, x *Jfif, p1, p2 string) bool {
     return ok
 }
 
-func TestInject(t *testing.T) {
-    var x = JfifData{
-        "UserComment": String("sample user comment"),
-        "gps.latitude": Rational{Num:550, Den:10},
-    }
+// lists the test images
+func testImages(t *testing.T) []string {
     // imgPath := path.Join(os.TempDir(), "go-basler-pylon-test")
     imgPath := testImagePath
     var e error
@@ -55,20 +56,24 @@ func TestInject(t *testing.T) {
     if len(lst) == 0 {
         t.Fatalf("No files in %#v", imgPath)
     }
+    var res []string
     for _, name := range lst {
         if path.Ext(name) != ".jpg" {
             t.Logf("Skipping %#v", name)
             continue
         }
-        path := path.Join(imgPath, name)
+        res = append(res, path.Join(imgPath, name))
+    }
+    return res
+}
+
+func TestRoundTrip(t *testing.T) {
+    for _, path := range testImages(t) {
         var X Jfif
-        if e = X.Load(path); e != nil {
+        if e := X.Load(path); e != nil {
             t.Fatalf("Cannot load %#v: %v", path, e)
         }
-        if e = X.Inject(x); e != nil {
-            t.Fatalf("Cannot Inject(%#v, %#v): %v", path, x, e)
-        }
-        if e = X.SaveTo(path + testOutputSuffix); e != nil {
+        if e := X.SaveTo(path + testOutputSuffix); e != nil {
             t.Fatalf("Cannot save %#v: %v", path + testOutputSuffix, e)
         } else {
             t.Logf("File %#v saved", path)
@@ -80,4 +85,222 @@ func TestInject(t *testing.T) {
         }
     }
 }
+
+func TestSave(t *testing.T) {
+    dir := t.TempDir()
+    for _, path := range testImages(t) {
+        var X Jfif
+        if e := X.Load(path); e != nil {
+            t.Fatalf("Cannot load %#v: %v", path, e)
+        }
+        out := filepath.Join(dir, filepath.Base(path))
+        // a longer file with unusual mode is to be replaced entirely
+        if e := os.WriteFile(out, make([]byte, X.Entries[len(X.Entries) - 1].Pos() + 1000), 0600); e != nil {
+            t.Fatalf("os.WriteFile(%q): %v", out, e)
+        }
+        if e := X.SaveTo(out); e != nil {
+            t.Fatalf("Cannot save %#v: %v", out, e)
+        }
+        if !compare(t, &X, path, out) {
+            t.Fatalf("Files differ")
+        }
+        if fi, e := os.Stat(out); e != nil || fi.Mode().Perm() != 0600 {
+            t.Errorf("Mode %v (%v)", fi.Mode(), e)
+        }
+        var Y Jfif
+        if e := Y.Load(out); e != nil {
+            t.Fatalf("Cannot load %#v: %v", out, e)
+        }
+        if e := Y.AddComment("saved"); e != nil {
+            t.Fatalf("Cannot add comment: %v", e)
+        }
+        if e := Y.Save(); e != nil {
+            t.Fatalf("Cannot save %#v: %v", out, e)
+        }
+        var Z Jfif
+        if e := Z.Load(out); e != nil {
+            t.Fatalf("Cannot load %#v: %v", out, e)
+        }
+        if c := Z.Comments(); len(c) != 1 || c[0] != "saved" {
+            t.Errorf("Got comments %q", c)
+        }
+        if lst, _ := filepath.Glob(filepath.Join(dir, ".*")); len(lst) != 0 {
+            t.Errorf("Temporary files left: %v", lst)
+        }
+    }
+    if e := new(Jfif).Save(); e == nil {
+        t.Errorf("Save() without path succeeded")
+    }
+
+    // a symlink stays in place, the file it points to is replaced
+    var X Jfif
+    if e := X.Load(testImages(t)[0]); e != nil {
+        t.Fatalf("Cannot load: %v", e)
+    }
+    target, link := filepath.Join(dir, "target.jpg"), filepath.Join(dir, "link.jpg")
+    if e := os.WriteFile(target, []byte("old"), 0600); e != nil {
+        t.Fatalf("os.WriteFile(%q): %v", target, e)
+    }
+    if e := os.Symlink("target.jpg", link); e != nil {
+        t.Skipf("os.Symlink(): %v", e)
+    }
+    if e := X.SaveTo(link); e != nil {
+        t.Fatalf("Cannot save %#v: %v", link, e)
+    }
+    if fi, e := os.Lstat(link); e != nil || fi.Mode() & os.ModeSymlink == 0 {
+        t.Errorf("Symlink is replaced: %v (%v)", fi.Mode(), e)
+    }
+    if !compare(t, &X, testImages(t)[0], target) {
+        t.Errorf("Symlink target is not saved")
+    }
+}
+
+func TestBytes(t *testing.T) {
+    for _, path := range testImages(t) {
+        data, e := ioutil.ReadFile(path)
+        if e != nil {
+            t.Fatalf("ioutil.ReadFile(%q): %v", path, e)
+        }
+        var X Jfif
+        if e = X.Parse(data); e != nil {
+            t.Fatalf("Cannot parse %#v: %v", path, e)
+        }
+        out, e := X.Bytes()
+        if e != nil {
+            t.Fatalf("Bytes(): %v", e)
+        }
+        if !bytes.Equal(out, data) {
+            t.Errorf("%#v: Bytes() differ", path)
+        }
+        var buf bytes.Buffer
+        if n, e := X.WriteTo(&buf); e != nil || n != int64(len(data)) {
+            t.Errorf("WriteTo(): %d of %d bytes (%v)", n, len(data), e)
+        }
+    }
+}
+
+func TestParse(t *testing.T) {
+    for _, path := range testImages(t) {
+        data, e := ioutil.ReadFile(path)
+        if e != nil {
+            t.Fatalf("ioutil.ReadFile(%q): %v", path, e)
+        }
+        var X, Y Jfif
+        if e = X.Load(path); e != nil {
+            t.Fatalf("Cannot load %#v: %v", path, e)
+        }
+        if e = Y.Parse(data); e != nil {
+            t.Fatalf("Cannot parse %#v: %v", path, e)
+        }
+        if len(X.Entries) != len(Y.Entries) {
+            t.Fatalf("Got %d entries, expected %d", len(Y.Entries), len(X.Entries))
+        }
+        for i := range X.Entries {
+            if X.Entries[i].String() != Y.Entries[i].String() {
+                t.Errorf("Entry %d: %s vs %s", i, Y.Entries[i], X.Entries[i])
+            }
+        }
+        if !Y.NoDataLeft {
+            t.Errorf("Data left in %#v", path)
+        }
+    }
+}
+
+func TestLogger(t *testing.T) {
+    for _, path := range testImages(t) {
+        var X Jfif
+        if e := X.Load(path); e != nil { // silent by default
+            t.Fatalf("Cannot load %#v: %v", path, e)
+        }
+        var out bytes.Buffer
+        X.Logger = slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+        if e := X.Load(path); e != nil {
+            t.Fatalf("Cannot load %#v: %v", path, e)
+        }
+        for _, s := range []string{"marker=SOI", "marker=SOS", "marker=EOI", "offset=0 "} {
+            if !strings.Contains(out.String(), s) {
+                t.Errorf("No %q in log:\n%s", s, out.String())
+            }
+        }
+    }
+}
+
+func TestComments(t *testing.T) {
+    const suffix = ".comments" + testOutputSuffix
+    for _, path := range testImages(t) {
+        var X Jfif
+        if e := X.Load(path); e != nil {
+            t.Fatalf("Cannot load %#v: %v", path, e)
+        }
+        if e := X.SetComments([]string{"build 42", "camera 7"}); e != nil {
+            t.Fatalf("Cannot set comments: %v", e)
+        }
+        if e := X.AddComment("frame 1"); e != nil {
+            t.Fatalf("Cannot add comment: %v", e)
+        }
+        if e := X.SaveTo(path + suffix); e != nil {
+            t.Fatalf("Cannot save %#v: %v", path + suffix, e)
+        }
+        var Y Jfif
+        if e := Y.Load(path + suffix); e != nil {
+            t.Fatalf("Cannot load %#v: %v", path + suffix, e)
+        }
+        if c := strings.Join(Y.Comments(), "|"); c != "build 42|camera 7|frame 1" {
+            t.Errorf("Got comments %q", c)
+        }
+        if e := Y.SetComments(nil); e != nil || len(Y.Comments()) != 0 {
+            t.Errorf("Comments left: %v (%v)", Y.Comments(), e)
+        }
+    }
+}
+
+func TestInject(t *testing.T) {
+    var x = JfifData{
+        "UserComment": String("sample user comment"),
+        "gps.latitude": Rational{Num:550, Den:10},
+    }
+    const suffix = ".inject" + testOutputSuffix
+    for _, path := range testImages(t) {
+        var X Jfif
+        if e := X.Load(path); e != nil {
+            t.Fatalf("Cannot load %#v: %v", path, e)
+        }
+        if e := X.Inject(x); e != nil {
+            t.Fatalf("Cannot Inject(%#v, %#v): %v", path, x, e)
+        }
+        if e := X.SaveTo(path + suffix); e != nil {
+            t.Fatalf("Cannot save %#v: %v", path + suffix, e)
+        }
+
+        var Y Jfif
+        if e := Y.Load(path + suffix); e != nil {
+            t.Fatalf("Cannot load %#v: %v", path + suffix, e)
+        }
+        if len(Y.Entries) != len(X.Entries) {
+            t.Fatalf("Got %d entries, expected %d", len(Y.Entries), len(X.Entries))
+        }
+        app, _ := Y.exifEntry(false)
+        if app == nil {
+            t.Fatalf("No Exif in %#v", path + suffix)
+        }
+        if Y.Entries[0].GetId() != SOI || Y.Entries[len(Y.Entries) - 1].GetId() != EOI {
+            t.Fatalf("Bad entries order: %v", Y.Entries)
+        }
+        exif, e := ParseExif(app.Data)
+        if e != nil {
+            t.Fatalf("Cannot parse Exif: %v", e)
+        }
+        if f := exif.Ifd[IFDExif].Get(0x9286); f == nil ||
+           string(f.Value) != "ASCII\x00\x00\x00sample user comment" {
+            t.Errorf("Bad UserComment: %v", f)
+        }
+        if f := exif.Ifd[IFDGps].Get(0x0001); f == nil || string(f.Value) != "N\x00" {
+            t.Errorf("Bad GPSLatitudeRef: %v", f)
+        }
+        if f := exif.Ifd[IFDGps].Get(0x0002); f == nil || f.Count != 3 ||
+           exif.Order.Uint32(f.Value) != 55 || exif.Order.Uint32(f.Value[4:]) != 1 {
+            t.Errorf("Bad GPSLatitude: %v", f)
+        }
+    }
+}
 /* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,153 @@
+package jfif
+
+import (
+    "fmt"
+    "bytes"
+    "encoding/binary"
+)
+
+const PhotoshopHeader = SigPhotoshop + "\x00"
+
+// resource data per APP13 segment
+const photoshopChunkSize = 65535 - 2 - len(PhotoshopHeader)
+
+// Photoshop image resource IDs of interest
+const (
+    ResourceIPTC = 0x0404
+    ResourceIPTCDigest = 0x0425
+)
+
+// Photoshop image resource block ("8BIM" one as a rule)
+type ImageResource struct {
+    Type string // "8BIM"
+    ID Word
+    Name string
+    Data []byte
+}
+
+func IsPhotoshop(data []byte) bool {
+    return bytes.HasPrefix(data, []byte(PhotoshopHeader))
+}
+
+// parses the image resource blocks (without the APP13 signature)
+func ParseImageResources(data []byte) ([]ImageResource, error) {
+    var res []ImageResource
+    for off := 0; off < len(data); {
+        if data[off] == 0 { // some writers pad the segment with zeroes
+            off++
+            continue
+        }
+        if off + 7 > len(data) {
+            return nil, fmt.Errorf("exif.ParseImageResources: truncated block at %d", off)
+        }
+        r := ImageResource{Type: string(data[off:off + 4]), ID: GetWordBE(data[off + 4:])}
+        n := int(data[off + 6])
+        p := off + 6 + (n + 2) &^ 1 // the name is padded to even size
+        if p + 4 > len(data) {
+            return nil, fmt.Errorf("exif.ParseImageResources: truncated block at %d", off)
+        }
+        r.Name = string(data[off + 7:off + 7 + n])
+        size := int(binary.BigEndian.Uint32(data[p:]))
+        p += 4
+        if size < 0 || p + size > len(data) {
+            return nil, fmt.Errorf("exif.ParseImageResources: block %04X at %d: %d bytes of %d",
+                                   r.ID, off, len(data) - p, size)
+        }
+        r.Data = data[p:p + size]
+        res = append(res, r)
+        off = p + (size + 1) &^ 1 // the data are padded as well
+    }
+    return res, nil
+}
+
+// serializes the image resource blocks
+func ImageResourcesBytes(res []ImageResource) []byte {
+    var data []byte
+    for _, r := range res {
+        typ := r.Type
+        if typ == "" { typ = "8BIM"; }
+        data = append(data, (typ + "\x00\x00\x00\x00")[:4]...)
+        data = binary.BigEndian.AppendUint16(data, uint16(r.ID))
+        name := r.Name
+        if len(name) > 255 { name = name[:255]; }
+        data = append(data, byte(len(name)))
+        data = append(data, name...)
+        if len(name) % 2 == 0 { data = append(data, 0); }
+        data = binary.BigEndian.AppendUint32(data, uint32(len(r.Data)))
+        data = append(data, r.Data...)
+        if len(r.Data) % 2 != 0 { data = append(data, 0); }
+    }
+    return data
+}
+
+func (x *Jfif) photoshopEntries() []*AppnEntry {
+    var res []*AppnEntry
+    for _, entry := range x.Entries {
+        if app, ok := entry.(*AppnEntry); ok && app.ID == APPd && IsPhotoshop(app.Data) {
+            res = append(res, app)
+        }
+    }
+    return res
+}
+
+// parses the Photoshop APP13 segments (their data are concatenated),
+// returns nil if there are none
+func (x *Jfif) ImageResources() ([]ImageResource, error) {
+    apps := x.photoshopEntries()
+    if apps == nil {
+        return nil, nil
+    }
+    var data []byte
+    for _, app := range apps {
+        data = append(data, app.Data[len(PhotoshopHeader):]...)
+    }
+    res, e := ParseImageResources(data)
+    if e != nil {
+        return nil, fmt.Errorf("exif.ImageResources(%q): %w", x.Path, e)
+    }
+    return res, nil
+}
+
+// replaces the Photoshop APP13 segments with the ones holding `res`, put
+// in place of the first old one or after the other APPn segments; nil
+// `res` just removes them
+func (x *Jfif) SetImageResources(res []ImageResource) error {
+    apps := x.photoshopEntries()
+    at := -1
+    if apps != nil {
+        at = x.Index(apps[0])
+    }
+    var entries []Entry
+    for _, entry := range x.Entries {
+        if app, ok := entry.(*AppnEntry); ok && app.ID == APPd && IsPhotoshop(app.Data) { continue; }
+        entries = append(entries, entry)
+    }
+    if e := x.setEntries(entries); e != nil {
+        return fmt.Errorf("exif.SetImageResources(%q): %w", x.Path, e)
+    }
+    if res == nil {
+        return nil
+    }
+    if at < 0 {
+        at = 0
+        for i, entry := range x.Entries {
+            id := entry.GetId()
+            if id == SOI || (id >= APP0 && id <= APPd) { at = i + 1; }
+            if id != SOI && id != COM && (id < APP0 || id > APPf) { break; }
+        }
+    }
+    data := ImageResourcesBytes(res)
+    for len(data) > 0 {
+        n := len(data)
+        if n > photoshopChunkSize { n = photoshopChunkSize; }
+        app := &AppnEntry{Xff0: 255, ID: APPd, Data: append([]byte(PhotoshopHeader), data[:n]...)}
+        if e := x.insert(at, app); e != nil {
+            return fmt.Errorf("exif.SetImageResources(%q): %w", x.Path, e)
+        }
+        data = data[n:]
+        at++
+    }
+    return nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
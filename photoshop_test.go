@@ -0,0 +1,124 @@
+package jfif
+
+import (
+    "bytes"
+    "strings"
+    "crypto/md5"
+)
+import "testing"
+
+func TestImageResources(t *testing.T) {
+    res := []ImageResource{
+        {Type: "8BIM", ID: 0x03ed, Data: []byte{0, 72, 0, 0, 0, 1, 0, 1, 0, 72, 0, 0, 0, 1, 0, 1}},
+        {Type: "8BIM", ID: 0x0424, Name: "odd", Data: []byte("abc")},
+        {Type: "8BIM", ID: 0x0425, Name: "ab", Data: make([]byte, 16)},
+    }
+    data := ImageResourcesBytes(res)
+    got, e := ParseImageResources(data)
+    if e != nil {
+        t.Fatalf("ParseImageResources(): %v", e)
+    }
+    if len(got) != len(res) {
+        t.Fatalf("Got %d resources: %v", len(got), got)
+    }
+    for i := range res {
+        if got[i].Type != res[i].Type || got[i].ID != res[i].ID || got[i].Name != res[i].Name ||
+           !bytes.Equal(got[i].Data, res[i].Data) {
+            t.Errorf("Got %+v, expected %+v", got[i], res[i])
+        }
+    }
+    if _, e = ParseImageResources(data[:len(data) - 3]); e == nil {
+        t.Errorf("Truncated resources parsed")
+    }
+}
+
+func TestIPTC(t *testing.T) {
+    var X Jfif
+    if e := X.Load(testImages(t)[0]); e != nil {
+        t.Fatalf("Cannot load: %v", e)
+    }
+    if iptc, e := X.IPTC(); iptc != nil || e != nil {
+        t.Fatalf("IPTC() of none: %v", e)
+    }
+    resolution := ImageResource{Type: "8BIM", ID: 0x03ed, Data: make([]byte, 16)}
+    digest := ImageResource{Type: "8BIM", ID: ResourceIPTCDigest, Data: make([]byte, 16)}
+    if e := X.SetImageResources([]ImageResource{resolution, digest}); e != nil {
+        t.Fatalf("SetImageResources(): %v", e)
+    }
+
+    var iptc Iptc
+    keywords := []string{"news", "Москва", strings.Repeat("k", 30000)}
+    for _, set := range []struct{ name string; values []string }{
+        {"caption", []string{"A caption"}},
+        {"Byline", []string{"Jane Doe"}},
+        {"Keywords", keywords},
+        {"Keywords", append(keywords, strings.Repeat("x", 40000))},
+        {"CopyrightNotice", []string{"(c) Agency"}},
+    } {
+        if e := iptc.Set(set.name, set.values...); e != nil {
+            t.Fatalf("Set(%s): %v", set.name, e)
+        }
+    }
+    if e := iptc.Set("Caption", "one", "two"); e == nil {
+        t.Errorf("Caption is not repeatable")
+    }
+    if e := iptc.Set("NoSuchThing", "x"); e == nil {
+        t.Errorf("Unknown dataset set")
+    }
+    if e := X.SetIPTC(iptc); e != nil {
+        t.Fatalf("SetIPTC(): %v", e)
+    }
+    if n := len(X.FindAll(APPd)); n != 2 {
+        t.Errorf("Got %d APP13 segments, expected 2", n)
+    }
+
+    data, e := X.Bytes()
+    if e != nil {
+        t.Fatalf("Bytes(): %v", e)
+    }
+    var Y Jfif
+    if e = Y.Parse(data); e != nil {
+        t.Fatalf("Parse(): %v", e)
+    }
+    got, e := Y.IPTC()
+    if e != nil {
+        t.Fatalf("IPTC(): %v", e)
+    }
+    if v := got.Get("Caption"); len(v) != 1 || v[0] != "A caption" {
+        t.Errorf("Caption: %q", v)
+    }
+    if v := got.Get("byline"); len(v) != 1 || v[0] != "Jane Doe" {
+        t.Errorf("Byline: %q", v)
+    }
+    if v := got.Get("Keywords"); len(v) != 4 || v[1] != "Москва" || len(v[3]) != 40000 {
+        t.Errorf("Keywords: %d values", len(v))
+    }
+    if got[0].Record != 1 || got[0].Dataset != 90 || got[1].Record != 2 || got[1].Dataset != 0 {
+        t.Errorf("Bad leading datasets %v", got[:2])
+    }
+    res, e := Y.ImageResources()
+    if e != nil {
+        t.Fatalf("ImageResources(): %v", e)
+    }
+    sum := md5.Sum(got.Bytes())
+    if len(res) != 3 || res[0].ID != 0x03ed || !bytes.Equal(res[1].Data, sum[:]) || res[2].ID != ResourceIPTC {
+        t.Errorf("Bad resources %v", res)
+    }
+
+    latin := Iptc{{Record: 2, Dataset: 120, Data: []byte("caf\xe9")}}
+    if v := latin.Get("Caption"); len(v) != 1 || v[0] != "café" {
+        t.Errorf("Latin-1 caption: %q", v)
+    }
+    latin = Iptc{{Record: 2, Dataset: 80, Data: []byte("Jos\xe9")}}
+    if e := latin.Set("City", "Paris"); e != nil {
+        t.Fatalf("Set(City): %v", e)
+    }
+    if v := latin.Get("Byline"); len(v) != 1 || v[0] != "José" {
+        t.Errorf("Latin-1 byline after Set(): %q", v)
+    }
+    if v := latin.Get("City"); len(v) != 1 || v[0] != "Paris" || !latin.utf8() {
+        t.Errorf("City %q, UTF-8 %v", v, latin.utf8())
+    }
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
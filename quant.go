@@ -0,0 +1,114 @@
+package jfif
+
+import (
+    "fmt"
+)
+
+// zigzag[i] is the natural (row-major) index of the i-th coefficient
+// in zigzag order
+var zigzag = [64]int{
+     0,  1,  8, 16,  9,  2,  3, 10,
+    17, 24, 32, 25, 18, 11,  4,  5,
+    12, 19, 26, 33, 40, 48, 41, 34,
+    27, 20, 13,  6,  7, 14, 21, 28,
+    35, 42, 49, 56, 57, 50, 43, 36,
+    29, 22, 15, 23, 30, 37, 44, 51,
+    58, 59, 52, 45, 38, 31, 39, 46,
+    53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// IJG (libjpeg) base tables in natural order, JPEG Annex K.1
+var StdLumaQuant = [64]int{
+    16,  11,  10,  16,  24,  40,  51,  61,
+    12,  12,  14,  19,  26,  58,  60,  55,
+    14,  13,  16,  24,  40,  57,  69,  56,
+    14,  17,  22,  29,  51,  87,  80,  62,
+    18,  22,  37,  56,  68, 109, 103,  77,
+    24,  35,  55,  64,  81, 104, 113,  92,
+    49,  64,  78,  87, 103, 121, 120, 101,
+    72,  92,  95,  98, 112, 100, 103,  99,
+}
+
+var StdChromaQuant = [64]int{
+    17,  18,  24,  47,  99,  99,  99,  99,
+    18,  21,  26,  66,  99,  99,  99,  99,
+    24,  26,  56,  99,  99,  99,  99,  99,
+    47,  66,  99,  99,  99,  99,  99,  99,
+    99,  99,  99,  99,  99,  99,  99,  99,
+    99,  99,  99,  99,  99,  99,  99,  99,
+    99,  99,  99,  99,  99,  99,  99,  99,
+    99,  99,  99,  99,  99,  99,  99,  99,
+}
+
+// the base table scaled the way libjpeg's jpeg_set_quality() does it
+func ScaleQuant(base *[64]int, quality int, precision Byte) [64]Word {
+    if quality < 1 { quality = 1; }
+    if quality > 100 { quality = 100; }
+    scale := 200 - quality * 2
+    if quality < 50 { scale = 5000 / quality; }
+    max := 255
+    if precision > 0 { max = 32767; }
+    var res [64]Word
+    for i, b := range base {
+        v := (b * scale + 50) / 100
+        if v < 1 { v = 1; }
+        if v > max { v = max; }
+        res[i] = Word(v)
+    }
+    return res
+}
+
+// all quantization tables by their destination ID (later ones win)
+func (x *Jfif) QuantTables() map[Byte]*QuantTable {
+    res := make(map[Byte]*QuantTable)
+    for _, entry := range x.Entries {
+        if dqt, ok := entry.(*DqtEntry); ok {
+            for i := range dqt.Tables {
+                res[dqt.Tables[i].ID] = &dqt.Tables[i]
+            }
+        }
+    }
+    return res
+}
+
+// approximate libjpeg quality factor (1..100) the image was saved with;
+// the table of the first frame component (table 0 if there is no frame)
+// is compared against the luma standard table and the one of the second
+// component (or table 1) against the chroma one
+func (x *Jfif) EstimateQuality() (int, error) {
+    tables := x.QuantTables()
+    luma, chroma := tables[0], tables[1]
+    if sof := x.Frame(); sof != nil && len(sof.Components) > 0 {
+        luma, chroma = tables[sof.Components[0].Tq], nil
+        if len(sof.Components) > 1 {
+            chroma = tables[sof.Components[1].Tq]
+        }
+    }
+    if luma == nil {
+        return 0, fmt.Errorf("exif.EstimateQuality(%q): no luma quantization table", x.Path)
+    }
+    best, bestDiff := 0, -1
+    for q := 1; q <= 100; q++ {
+        diff := quantDiff(luma, &StdLumaQuant, q)
+        if chroma != nil {
+            diff += quantDiff(chroma, &StdChromaQuant, q)
+        }
+        if bestDiff < 0 || diff < bestDiff {
+            best, bestDiff = q, diff
+        }
+    }
+    return best, nil
+}
+
+func quantDiff(qt *QuantTable, base *[64]int, quality int) int {
+    std := ScaleQuant(base, quality, qt.Precision)
+    diff := 0
+    for i, v := range qt.Natural() {
+        d := int(v) - int(std[i])
+        if d < 0 { d = -d; }
+        diff += d
+    }
+    return diff
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,31 @@
+package jfif
+
+import "testing"
+
+func TestEstimateQuality(t *testing.T) {
+    for _, quality := range []int{10, 50, 75, 90, 100} {
+        var dqt DqtEntry
+        for id, base := range []*[64]int{&StdLumaQuant, &StdChromaQuant} {
+            qt := QuantTable{ID: Byte(id)}
+            std := ScaleQuant(base, quality, 0)
+            for i := range qt.Values { qt.Values[i] = std[zigzag[i]]; }
+            dqt.Tables = append(dqt.Tables, qt)
+        }
+        X := Jfif{Entries: []Entry{&dqt}}
+        if q, e := X.EstimateQuality(); e != nil || q != quality {
+            t.Errorf("Quality %d estimated as %d (%v)", quality, q, e)
+        }
+    }
+    for _, path := range testImages(t) {
+        var X Jfif
+        if e := X.Load(path); e != nil {
+            t.Fatalf("Cannot load %#v: %v", path, e)
+        }
+        q, e := X.EstimateQuality()
+        if e != nil || q < 1 || q > 100 {
+            t.Errorf("%#v: quality %d (%v)", path, q, e)
+        }
+        t.Logf("%#v: quality %d", path, q)
+    }
+}
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
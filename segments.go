@@ -0,0 +1,193 @@
+package jfif
+
+import (
+    "fmt"
+)
+
+// index of the `entry` in x.Entries, -1 if it is not there
+func (x *Jfif) Index(entry Entry) int {
+    for i, ent := range x.Entries {
+        if ent == entry { return i; }
+    }
+    return -1
+}
+
+// the first segment with the marker `id`, nil if there is none
+func (x *Jfif) Find(id Byte) Entry {
+    for _, entry := range x.Entries {
+        if entry.GetId() == id { return entry; }
+    }
+    return nil
+}
+
+// all segments with the marker `id` in file order
+func (x *Jfif) FindAll(id Byte) []Entry {
+    var res []Entry
+    for _, entry := range x.Entries {
+        if entry.GetId() == id { res = append(res, entry); }
+    }
+    return res
+}
+
+// the segment order the editing methods keep: SOI comes first and EOI last,
+// a scan follows the frame header and the quantization and Huffman tables
+// it uses
+func checkOrder(entries []Entry) error {
+    n := len(entries)
+    if n < 2 || entries[0].GetId() != SOI || entries[n-1].GetId() != EOI {
+        return fmt.Errorf("SOI must be the first and EOI the last segment")
+    }
+    var frame *SofEntry
+    var dq, dc, ac [4]bool
+    for _, entry := range entries[1:n-1] {
+        switch ent := entry.(type) {
+        case *SoiEntry, *EoiEntry:
+            return fmt.Errorf("extra %s segment", EntryName[entry.GetId()])
+        case *DqtEntry:
+            for _, qt := range ent.Tables { dq[qt.ID & 3] = true; }
+        case *DhtEntry:
+            for _, ht := range ent.Tables {
+                if ht.Class == 0 { dc[ht.ID & 3] = true; } else { ac[ht.ID & 3] = true; }
+            }
+        case *SofEntry:
+            if frame != nil { return fmt.Errorf("extra frame header %s", EntryName[ent.ID]); }
+            frame = ent
+        case *SosEntry:
+            if frame == nil { return fmt.Errorf("SOS before the frame header"); }
+            for _, c := range ent.Components {
+                for _, fc := range frame.Components {
+                    if fc.ID == c.Id && !dq[fc.Tq & 3] {
+                        return fmt.Errorf("SOS before DQT table %d", fc.Tq & 3)
+                    }
+                }
+            }
+            if frame.ID >= SOF9 { continue; } // arithmetic coding, no DHT
+            ss, se := ent.SpectralSelection()
+            ah, _ := ent.SuccessiveApproximation()
+            for _, c := range ent.Components {
+                if ss == 0 && ah == 0 && !dc[c.Ht >> 4 & 3] {
+                    return fmt.Errorf("SOS before DC table %d", c.Ht >> 4 & 3)
+                }
+                if se > 0 && !ac[c.Ht & 3] {
+                    return fmt.Errorf("SOS before AC table %d", c.Ht & 3)
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// replaces x.Entries unless that breaks the order (see checkOrder); the
+// images that are already out of order are not checked
+func (x *Jfif) setEntries(entries []Entry) error {
+    if checkOrder(x.Entries) == nil {
+        if e := checkOrder(entries); e != nil { return e; }
+    }
+    x.Entries = entries
+    return nil
+}
+
+func (x *Jfif) locate(entry Entry) (int, error) {
+    if entry == nil {
+        return -1, fmt.Errorf("no segment")
+    }
+    if i := x.Index(entry); i >= 0 {
+        return i, nil
+    }
+    return -1, fmt.Errorf("%s segment is not in the image", EntryName[entry.GetId()])
+}
+
+// x.Entries with the `entry` put at `at`, the `skip` index left out
+func (x *Jfif) spliced(at, skip int, entry Entry) []Entry {
+    entries := make([]Entry, 0, len(x.Entries) + 1)
+    for i, ent := range x.Entries {
+        if i == at { entries = append(entries, entry); }
+        if i != skip { entries = append(entries, ent); }
+    }
+    if at >= len(x.Entries) { entries = append(entries, entry); }
+    return entries
+}
+
+func (x *Jfif) insert(at int, entry Entry) error {
+    if entry == nil {
+        return fmt.Errorf("no segment")
+    }
+    if x.Index(entry) >= 0 {
+        return fmt.Errorf("%s segment is in the image already", EntryName[entry.GetId()])
+    }
+    return x.setEntries(x.spliced(at, -1, entry))
+}
+
+// puts the new `entry` right before the `at` one
+func (x *Jfif) InsertBefore(at, entry Entry) error {
+    i, e := x.locate(at)
+    if e == nil { e = x.insert(i, entry); }
+    if e != nil {
+        return fmt.Errorf("exif.InsertBefore(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+// puts the new `entry` right after the `at` one
+func (x *Jfif) InsertAfter(at, entry Entry) error {
+    i, e := x.locate(at)
+    if e == nil { e = x.insert(i + 1, entry); }
+    if e != nil {
+        return fmt.Errorf("exif.InsertAfter(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+// puts the new `entry` in place of the `old` one
+func (x *Jfif) Replace(old, entry Entry) error {
+    i, e := x.locate(old)
+    if e == nil && entry == nil { e = fmt.Errorf("no segment"); }
+    if e == nil && entry != old && x.Index(entry) >= 0 {
+        e = fmt.Errorf("%s segment is in the image already", EntryName[entry.GetId()])
+    }
+    if e == nil { e = x.setEntries(x.spliced(i, i, entry)); }
+    if e != nil {
+        return fmt.Errorf("exif.Replace(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+// drops the `entry` from the image
+func (x *Jfif) Remove(entry Entry) error {
+    i, e := x.locate(entry)
+    if e == nil {
+        entries := append(append([]Entry{}, x.Entries[:i]...), x.Entries[i+1:]...)
+        e = x.setEntries(entries)
+    }
+    if e != nil {
+        return fmt.Errorf("exif.Remove(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+func (x *Jfif) move(entry, at Entry, after bool) error {
+    i, e := x.locate(entry)
+    if e != nil { return e; }
+    j, e := x.locate(at)
+    if e != nil { return e; }
+    if after { j++; }
+    return x.setEntries(x.spliced(j, i, entry))
+}
+
+// moves the `entry` right before the `at` one
+func (x *Jfif) MoveBefore(entry, at Entry) error {
+    if e := x.move(entry, at, false); e != nil {
+        return fmt.Errorf("exif.MoveBefore(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+// moves the `entry` right after the `at` one
+func (x *Jfif) MoveAfter(entry, at Entry) error {
+    if e := x.move(entry, at, true); e != nil {
+        return fmt.Errorf("exif.MoveAfter(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,82 @@
+package jfif
+
+import "testing"
+
+func segmentIds(x *Jfif) string {
+    var res string
+    for _, entry := range x.Entries {
+        if res != "" { res += " "; }
+        res += EntryName[entry.GetId()]
+    }
+    return res
+}
+
+func TestSegments(t *testing.T) {
+    soi := &SoiEntry{Xff0: 255, ID: SOI}
+    app := &AppnEntry{Xff0: 255, ID: APP1, Data: []byte("a")}
+    dqt := &DqtEntry{Xff0: 255, ID: DQT, Tables: []QuantTable{{}}}
+    sof := &SofEntry{Xff0: 255, ID: SOF0, Components: []FrameComponent{{ID: 1, H: 1, V: 1}}}
+    dht := &DhtEntry{Xff0: 255, ID: DHT, Tables: []HuffmanTable{{Class: 0}, {Class: 1}}}
+    sos := &SosEntry{Xff0: 255, ID: SOS, Components: []SosComponent{{Id: 1}}}
+    com := &CommentEntry{Xff0: 255, ID: COM, Data: []byte("x")}
+    eoi := &EoiEntry{Xff0: 255, ID: EOI}
+    X := Jfif{Entries: []Entry{soi, app, dqt, sof, dht, sos, com, eoi}}
+
+    if X.Find(APP1) != app || X.Find(APP2) != nil || X.Index(com) != 6 {
+        t.Errorf("Find(): %v", X.Entries)
+    }
+    if lst := X.FindAll(DHT); len(lst) != 1 || lst[0] != dht {
+        t.Errorf("FindAll(DHT): %v", lst)
+    }
+
+    check := func(what string, e error, ok bool, ids string) {
+        t.Helper()
+        if ok && e != nil {
+            t.Errorf("%s: %v", what, e)
+        } else if !ok && e == nil {
+            t.Errorf("%s: no error", what)
+        }
+        if got := segmentIds(&X); got != ids {
+            t.Errorf("%s: got %q, expected %q", what, got, ids)
+        }
+    }
+    app2 := &AppnEntry{Xff0: 255, ID: APP2}
+    check("InsertAfter(app)", X.InsertAfter(app, app2), true,
+          "SOI APP1 APP2 DQT SOF0 DHT SOS COM EOI")
+    check("InsertAfter(app) again", X.InsertAfter(app, app2), false,
+          "SOI APP1 APP2 DQT SOF0 DHT SOS COM EOI")
+    check("InsertBefore(soi)", X.InsertBefore(soi, &CommentEntry{Xff0: 255, ID: COM}), false,
+          "SOI APP1 APP2 DQT SOF0 DHT SOS COM EOI")
+    check("InsertAfter(eoi)", X.InsertAfter(eoi, &CommentEntry{Xff0: 255, ID: COM}), false,
+          "SOI APP1 APP2 DQT SOF0 DHT SOS COM EOI")
+    check("MoveBefore(com, app)", X.MoveBefore(com, app), true,
+          "SOI COM APP1 APP2 DQT SOF0 DHT SOS EOI")
+    check("MoveAfter(app, app2)", X.MoveAfter(app, app2), true,
+          "SOI COM APP2 APP1 DQT SOF0 DHT SOS EOI")
+    check("MoveAfter(dqt, sof)", X.MoveAfter(dqt, sof), true,
+          "SOI COM APP2 APP1 SOF0 DQT DHT SOS EOI")
+    check("MoveAfter(dqt, sos)", X.MoveAfter(dqt, sos), false,
+          "SOI COM APP2 APP1 SOF0 DQT DHT SOS EOI")
+    check("MoveBefore(dqt, sof)", X.MoveBefore(dqt, sof), true,
+          "SOI COM APP2 APP1 DQT SOF0 DHT SOS EOI")
+    check("MoveAfter(dht, sos)", X.MoveAfter(dht, sos), false,
+          "SOI COM APP2 APP1 DQT SOF0 DHT SOS EOI")
+    check("MoveBefore(sos, sof)", X.MoveBefore(sos, sof), false,
+          "SOI COM APP2 APP1 DQT SOF0 DHT SOS EOI")
+    check("MoveBefore(dht, sof)", X.MoveBefore(dht, sof), true,
+          "SOI COM APP2 APP1 DQT DHT SOF0 SOS EOI")
+    check("Remove(soi)", X.Remove(soi), false,
+          "SOI COM APP2 APP1 DQT DHT SOF0 SOS EOI")
+    check("Remove(com)", X.Remove(com), true,
+          "SOI APP2 APP1 DQT DHT SOF0 SOS EOI")
+    check("Remove(com) again", X.Remove(com), false,
+          "SOI APP2 APP1 DQT DHT SOF0 SOS EOI")
+    check("Replace(app2)", X.Replace(app2, com), true,
+          "SOI COM APP1 DQT DHT SOF0 SOS EOI")
+    check("Replace(eoi)", X.Replace(eoi, &AppnEntry{Xff0: 255, ID: APP2}), false,
+          "SOI COM APP1 DQT DHT SOF0 SOS EOI")
+    check("Replace(sof)", X.Replace(sof, &SofEntry{Xff0: 255, ID: SOF2}), true,
+          "SOI COM APP1 DQT DHT SOF2 SOS EOI")
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
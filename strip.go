@@ -0,0 +1,81 @@
+package jfif
+
+import (
+    "fmt"
+)
+
+// APPn segment signatures (see AppnEntry.Signature)
+const (
+    SigExif      = "Exif"
+    SigXMP       = "http://ns.adobe.com/xap/1.0/"
+    SigXMPExt    = "http://ns.adobe.com/xmp/extension/"
+    SigICC       = "ICC_PROFILE"
+    SigPhotoshop = "Photoshop 3.0"
+    SigAdobe     = "Adobe"
+)
+
+// which APPn and COM segments Strip keeps: denied ones are removed, allowed
+// ones are kept, the rest is removed; JFIF APP0 and Adobe APP14 describe
+// the pixel data and are always kept
+type StripPolicy struct {
+    AllowMarkers, DenyMarkers []Byte
+    AllowSignatures, DenySignatures []string
+    Orientation bool // keep the Exif Orientation alone if Exif is removed
+}
+
+var (
+    KeepNone = StripPolicy{}
+    KeepICCOnly = StripPolicy{AllowSignatures: []string{SigICC}}
+    KeepOrientation = StripPolicy{Orientation: true}
+)
+
+func (p *StripPolicy) keeps(entry Entry) bool {
+    id, sig := entry.GetId(), ""
+    if app, ok := entry.(*AppnEntry); ok {
+        sig = app.Signature()
+        if id == APPe && sig == SigAdobe { return true; }
+        if sig == SigXMPExt { sig = SigXMP; } // goes along with the main packet
+    }
+    for _, m := range p.DenyMarkers {
+        if m == id { return false; }
+    }
+    for _, s := range p.DenySignatures {
+        if sig != "" && s == sig { return false; }
+    }
+    for _, m := range p.AllowMarkers {
+        if m == id { return true; }
+    }
+    for _, s := range p.AllowSignatures {
+        if sig != "" && s == sig { return true; }
+    }
+    return false
+}
+
+// removes APPn (but JFIF APP0) and COM segments according to the `policy`;
+// an Exif segment that cannot be parsed loses its orientation as well
+func (x *Jfif) Strip(policy StripPolicy) error {
+    x.debug("exif.Strip", "path", x.Path)
+    orientation := TransformNone
+    if policy.Orientation {
+        orientation, _ = x.Orientation()
+    }
+    var entries []Entry
+    for _, entry := range x.Entries {
+        if id := entry.GetId(); (id == COM || id >= APP1 && id <= APPf) && !policy.keeps(entry) {
+            x.debug("exif.Strip: segment", entryAttrs(entry)...)
+            continue
+        }
+        entries = append(entries, entry)
+    }
+    x.Entries = entries
+    if app, _ := x.exifEntry(false); app == nil && orientation != TransformNone {
+        exif := NewExif()
+        if e := exif.SetValue("Orientation", Word(orientation)); e != nil {
+            return fmt.Errorf("exif.Strip(%q): %w", x.Path, e)
+        }
+        return x.SetExif(exif)
+    }
+    return nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,73 @@
+package jfif
+
+import "testing"
+
+func stripSample(t *testing.T) *Jfif {
+    t.Helper()
+    var X Jfif
+    if e := X.Load(testImages(t)[0]); e != nil {
+        t.Fatalf("Cannot load: %v", e)
+    }
+    e := X.Inject(JfifData{
+        "Orientation": Word(6),
+        "BodySerialNumber": String("12345"),
+        "gps.latitude": Rational{Num: 550, Den: 10},
+    })
+    if e != nil {
+        t.Fatalf("Inject(): %v", e)
+    }
+    icc := &AppnEntry{Xff0: 255, ID: APP2, Data: []byte("ICC_PROFILE\x00\x01\x01profile")}
+    xmp := &AppnEntry{Xff0: 255, ID: APP1, Data: []byte(SigXMP + "\x00<x:xmpmeta/>")}
+    adobe := &AppnEntry{Xff0: 255, ID: APPe, Data: []byte("Adobe\x00\x64\x00\x00\x00\x00\x01")}
+    for _, app := range []*AppnEntry{icc, xmp, adobe} {
+        if e = X.InsertAfter(X.Find(APP1), app); e != nil {
+            t.Fatalf("InsertAfter(): %v", e)
+        }
+    }
+    if e = X.AddComment("secret"); e != nil {
+        t.Fatalf("AddComment(): %v", e)
+    }
+    return &X
+}
+
+func TestStrip(t *testing.T) {
+    tests := []struct{
+        name string
+        policy StripPolicy
+        ids string
+        orientation bool
+    }{
+        {"none", KeepNone, "SOI APP0 APPe DQT", false},
+        {"icc", KeepICCOnly, "SOI APP0 APPe APP2 DQT", false},
+        {"orientation", KeepOrientation, "SOI APP0 APP1 APPe DQT", true},
+        {"upload", StripPolicy{AllowSignatures: []string{SigICC}, Orientation: true},
+         "SOI APP0 APP1 APPe APP2 DQT", true},
+        {"app1 but exif", StripPolicy{AllowMarkers: []Byte{APP1, COM}, DenySignatures: []string{SigExif}},
+         "SOI APP0 APPe APP1 COM DQT", false},
+    }
+    for _, test := range tests {
+        X := stripSample(t)
+        if e := X.Strip(test.policy); e != nil {
+            t.Fatalf("%s: Strip(): %v", test.name, e)
+        }
+        if ids := segmentIds(X); ids[:len(test.ids)] != test.ids {
+            t.Errorf("%s: got %q, expected %q...", test.name, ids, test.ids)
+        }
+        exif, e := X.Exif()
+        if e != nil {
+            t.Fatalf("%s: Exif(): %v", test.name, e)
+        }
+        if test.orientation {
+            if values := exif.Values(); len(values) != 1 || values[0].Name != "Orientation" {
+                t.Errorf("%s: Exif kept %v", test.name, values)
+            }
+            if o, _ := X.Orientation(); o != Rotate90 {
+                t.Errorf("%s: orientation %v", test.name, o)
+            }
+        } else if exif != nil {
+            t.Errorf("%s: Exif kept", test.name)
+        }
+    }
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,341 @@
+package jfif
+
+import (
+    "fmt"
+)
+
+// lossless transforms; the values match the Exif Orientation ones, i.e.
+// Transform(orientation) is what has to be done to display the image
+type Transform int
+
+const (
+    TransformNone Transform = iota + 1
+    FlipHorizontal
+    Rotate180
+    FlipVertical
+    Transpose
+    Rotate90    // clockwise
+    Transverse
+    Rotate270   // clockwise
+)
+
+var TransformName = map[Transform]string{
+    TransformNone: "none",
+    FlipHorizontal: "flip-horizontal",
+    Rotate180: "rotate-180",
+    FlipVertical: "flip-vertical",
+    Transpose: "transpose",
+    Rotate90: "rotate-90",
+    Transverse: "transverse",
+    Rotate270: "rotate-270",
+}
+
+func (t Transform) String() string {
+    if name, ok := TransformName[t]; ok {
+        return name
+    }
+    return fmt.Sprintf("transform(%d)", int(t))
+}
+
+// (x, y) -> (m[0]x + m[1]y, m[2]x + m[3]y), y goes down
+var transformMatrix = map[Transform][4]int{
+    TransformNone:  { 1,  0,  0,  1},
+    FlipHorizontal: {-1,  0,  0,  1},
+    Rotate180:      {-1,  0,  0, -1},
+    FlipVertical:   { 1,  0,  0, -1},
+    Transpose:      { 0,  1,  1,  0},
+    Rotate90:       { 0, -1,  1,  0},
+    Transverse:     { 0, -1, -1,  0},
+    Rotate270:      { 0,  1, -1,  0},
+}
+
+// `t` applied after `u`
+func (t Transform) compose(u Transform) Transform {
+    a, b := transformMatrix[t], transformMatrix[u]
+    m := [4]int{
+        a[0] * b[0] + a[1] * b[2], a[0] * b[1] + a[1] * b[3],
+        a[2] * b[0] + a[3] * b[2], a[2] * b[1] + a[3] * b[3],
+    }
+    for r, rm := range transformMatrix {
+        if rm == m { return r; }
+    }
+    return TransformNone // unreachable for valid transforms
+}
+
+func (t Transform) inverse() Transform {
+    m := transformMatrix[t]
+    mt := [4]int{m[0], m[2], m[1], m[3]} // orthogonal: inverse is transposed
+    for r, rm := range transformMatrix {
+        if rm == mt { return r; }
+    }
+    return TransformNone
+}
+
+// whether the transform swaps width and height
+func (t Transform) transposes() bool {
+    return t == Transpose || t == Rotate90 || t == Transverse || t == Rotate270
+}
+
+// whether the source image is mirrored along its x (y) axis; the partial
+// MCUs on the right (bottom) edge cannot be moved and are trimmed off
+func (t Transform) flipsX() bool {
+    return t == FlipHorizontal || t == Rotate180 || t == Transverse || t == Rotate270
+}
+func (t Transform) flipsY() bool {
+    return t == FlipVertical || t == Rotate180 || t == Transverse || t == Rotate90
+}
+
+// source block for the destination one; sw, sh are the source block counts
+func (t Transform) sourceBlock(dx, dy, sw, sh int) (int, int) {
+    switch t {
+    case FlipHorizontal: return sw - 1 - dx, dy
+    case FlipVertical: return dx, sh - 1 - dy
+    case Rotate180: return sw - 1 - dx, sh - 1 - dy
+    case Transpose: return dy, dx
+    case Rotate90: return dy, sh - 1 - dx
+    case Rotate270: return sw - 1 - dy, dx
+    case Transverse: return sw - 1 - dy, sh - 1 - dx
+    }
+    return dx, dy
+}
+
+// transforms DCT coefficients of a block (natural order)
+func (t Transform) block(src, dst *[64]int32) {
+    for v := 0; v < 8; v++ {
+        for u := 0; u < 8; u++ {
+            i := v * 8 + u
+            if t.transposes() { i = u * 8 + v; }
+            c := src[i]
+            // mirroring negates the odd frequencies of the mirrored axis
+            switch t {
+            case FlipHorizontal, Rotate90: if u & 1 != 0 { c = -c; }
+            case FlipVertical, Rotate270: if v & 1 != 0 { c = -c; }
+            case Rotate180, Transverse: if (u + v) & 1 != 0 { c = -c; }
+            }
+            dst[v * 8 + u] = c
+        }
+    }
+}
+
+// applies the transform to the coefficients of the frame; the result
+// has the trimmed and possibly swapped dimensions and sampling factors
+func (img *coefImage) transform(t Transform) (*coefImage, error) {
+    sof := img.frame
+    w, h := int(sof.Width), int(sof.Height)
+    mw, mh := sof.McuSize()
+    if t.flipsX() { w -= w % mw; }
+    if t.flipsY() { h -= h % mh; }
+    if w == 0 || h == 0 {
+        return nil, fmt.Errorf("image %dx%d is smaller than an MCU", sof.Width, sof.Height)
+    }
+    frame := *sof
+    frame.Components = append([]FrameComponent(nil), sof.Components...)
+    frame.Width, frame.Height = Word(w), Word(h)
+    if t.transposes() {
+        frame.Width, frame.Height = frame.Height, frame.Width
+        for i := range frame.Components {
+            c := &frame.Components[i]
+            c.H, c.V = c.V, c.H
+        }
+    }
+    res := newCoefImage(&frame)
+    H, V := sof.MaxSampling()
+    for i, sp := range img.planes {
+        dp := res.planes[i]
+        dp.quant = sp.quant
+        if t.transposes() {
+            for v := 0; v < 8; v++ {
+                for u := 0; u < 8; u++ { dp.quant[v * 8 + u] = sp.quant[u * 8 + v]; }
+            }
+        }
+        sw, sh := sp.bw, sp.bh
+        if len(img.planes) > 1 {
+            if t.flipsX() { sw = w / 8 * int(sp.H) / int(H); }
+            if t.flipsY() { sh = h / 8 * int(sp.V) / int(V); }
+        } else {
+            if t.flipsX() { sw = w / 8; }
+            if t.flipsY() { sh = h / 8; }
+        }
+        for dy := 0; dy < dp.bh; dy++ {
+            for dx := 0; dx < dp.bw; dx++ {
+                sx, sy := t.sourceBlock(dx, dy, sw, sh)
+                if sx < 0 || sy < 0 || sx >= sw || sy >= sh { continue; }
+                t.block(sp.block(sx, sy), dp.block(dx, dy))
+            }
+        }
+    }
+    return res, nil
+}
+
+// replaces the scan data with the coefficients of `img` and updates
+// the frame header and quantization tables accordingly
+func (x *Jfif) storeCoefficients(img *coefImage, transposed bool) error {
+    if e := x.encodeScans(img); e != nil {
+        return e
+    }
+    sof := x.Frame()
+    sof.Width, sof.Height = img.frame.Width, img.frame.Height
+    sof.Components = img.frame.Components
+    if transposed {
+        for _, entry := range x.Entries {
+            if dqt, ok := entry.(*DqtEntry); ok {
+                for i := range dqt.Tables {
+                    qt := &dqt.Tables[i]
+                    n := qt.Natural()
+                    for k, z := range zigzag {
+                        qt.Values[k] = n[z % 8 * 8 + z / 8]
+                    }
+                }
+            }
+        }
+    }
+    return nil
+}
+
+// Exif Orientation, 1 if there is none
+func (x *Jfif) Orientation() (Transform, error) {
+    exif, e := x.Exif()
+    if e != nil || exif == nil {
+        return TransformNone, e
+    }
+    if v, ok := exif.Value("Orientation"); ok {
+        if o, ok := v.(Word); ok && o >= 1 && o <= 8 {
+            return Transform(o), nil
+        }
+    }
+    return TransformNone, nil
+}
+
+// stores the coefficients of `img` (see storeCoefficients) and lets
+// `update` adjust the Exif structure, if there is one, to the new frame
+func (x *Jfif) replaceFrame(img *coefImage, transposed bool, update func(*Exif) error) error {
+    exif, e := x.Exif()
+    if e != nil {
+        return e
+    }
+    if exif != nil {
+        if e = update(exif); e != nil {
+            return e
+        }
+        for _, tag := range []string{"PixelXDimension", "PixelYDimension"} {
+            if _, ok := exif.Value(tag); ok {
+                v := Long(img.frame.Width)
+                if tag == "PixelYDimension" { v = Long(img.frame.Height); }
+                if e = exif.SetValue(tag, v); e != nil {
+                    return e
+                }
+            }
+        }
+    }
+    if e = x.storeCoefficients(img, transposed); e != nil {
+        return e
+    }
+    if exif != nil {
+        return x.SetExif(exif)
+    }
+    return nil
+}
+
+func (x *Jfif) transform(t Transform, orientation func(Transform) Transform) error {
+    if _, ok := transformMatrix[t]; !ok {
+        return fmt.Errorf("exif.Transform(%q): bad transform %d", x.Path, int(t))
+    }
+    img, e := x.decodeCoefficients()
+    if e != nil {
+        return fmt.Errorf("exif.Transform(%q): %w", x.Path, e)
+    }
+    res, e := img.transform(t)
+    if e != nil {
+        return fmt.Errorf("exif.Transform(%q): %w", x.Path, e)
+    }
+    e = x.replaceFrame(res, t.transposes(), func(exif *Exif) error {
+        o := TransformNone
+        if v, ok := exif.Value("Orientation"); ok {
+            if w, ok := v.(Word); ok && w >= 1 && w <= 8 { o = Transform(w); }
+        }
+        if n := orientation(o); n != o || exif.Ifd[IFD0].Get(0x0112) != nil {
+            return exif.SetValue("Orientation", Word(n))
+        }
+        return nil
+    })
+    if e != nil {
+        return fmt.Errorf("exif.Transform(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+// transforms a baseline image losslessly (as jpegtran -trim does: partial
+// MCUs on the edges that would have to move are dropped); the Exif
+// Orientation is adjusted so the displayed picture is transformed as well
+func (x *Jfif) Transform(t Transform) error {
+    return x.transform(t, func(o Transform) Transform {
+        return t.compose(o).compose(t.inverse())
+    })
+}
+
+// applies the Exif Orientation to the pixels and resets it to 1
+func (x *Jfif) Normalize() error {
+    o, e := x.Orientation()
+    if e != nil {
+        return fmt.Errorf("exif.Normalize(%q): %w", x.Path, e)
+    }
+    if o == TransformNone {
+        return nil
+    }
+    return x.transform(o, func(Transform) Transform { return TransformNone; })
+}
+
+// cuts out the `w`x`h` region at `x`,`y`; the origin is moved up and left
+// to the nearest MCU boundary (and the size grows accordingly) so no block
+// is recompressed, the region is clipped to the image; the thumbnails no
+// longer match the picture and are dropped
+func (x *Jfif) Crop(left, top, w, h int) error {
+    img, e := x.decodeCoefficients()
+    if e != nil {
+        return fmt.Errorf("exif.Crop(%q): %w", x.Path, e)
+    }
+    sof := img.frame
+    if left < 0 || top < 0 || w <= 0 || h <= 0 || left >= int(sof.Width) || top >= int(sof.Height) {
+        return fmt.Errorf("exif.Crop(%q): region %dx%d+%d+%d is out of %dx%d image",
+                          x.Path, w, h, left, top, sof.Width, sof.Height)
+    }
+    mw, mh := sof.McuSize()
+    w, h = w + left % mw, h + top % mh
+    left, top = left - left % mw, top - top % mh
+    if left + w > int(sof.Width) { w = int(sof.Width) - left; }
+    if top + h > int(sof.Height) { h = int(sof.Height) - top; }
+
+    frame := *sof
+    frame.Width, frame.Height = Word(w), Word(h)
+    res := newCoefImage(&frame)
+    H, V := sof.MaxSampling()
+    for i, sp := range img.planes {
+        dp := res.planes[i]
+        dp.quant = sp.quant
+        ox, oy := left / 8, top / 8
+        if len(img.planes) > 1 {
+            ox, oy = ox * int(sp.H) / int(H), oy * int(sp.V) / int(V)
+        }
+        for dy := 0; dy < dp.bh && dy + oy < sp.bh; dy++ {
+            for dx := 0; dx < dp.bw && dx + ox < sp.bw; dx++ {
+                *dp.block(dx, dy) = *sp.block(dx + ox, dy + oy)
+            }
+        }
+    }
+
+    e = x.replaceFrame(res, false, func(exif *Exif) error {
+        exif.Ifd[IFD1], exif.Thumbnail = nil, nil
+        return nil
+    })
+    if e != nil {
+        return fmt.Errorf("exif.Crop(%q): %w", x.Path, e)
+    }
+    for _, entry := range x.Entries {
+        if app0, ok := entry.(*App0Entry); ok && app0.Data != nil {
+            app0.Xthumbnail, app0.Ythumbnail, app0.Data = 0, 0, nil
+        }
+    }
+    return nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
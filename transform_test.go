@@ -0,0 +1,182 @@
+package jfif
+
+import (
+    "bytes"
+    "image"
+    "image/jpeg"
+)
+import "testing"
+
+// the pixel of `src` the transform puts at (x, y) of the `w`x`h` result
+func transformedAt(t Transform, x, y, w, h int) (int, int) {
+    switch t {
+    case FlipHorizontal: return w - 1 - x, y
+    case FlipVertical: return x, h - 1 - y
+    case Rotate180: return w - 1 - x, h - 1 - y
+    case Transpose: return y, x
+    case Rotate90: return y, w - 1 - x
+    case Rotate270: return h - 1 - y, x
+    case Transverse: return h - 1 - y, w - 1 - x
+    }
+    return x, y
+}
+
+func TestTransform(t *testing.T) {
+    data := testJpeg(t, 77, 45)
+    src, e := jpeg.Decode(bytes.NewReader(data))
+    if e != nil {
+        t.Fatalf("jpeg.Decode(): %v", e)
+    }
+    for tr := TransformNone; tr <= Rotate270; tr++ {
+        var X Jfif
+        if e = X.Parse(data); e != nil {
+            t.Fatalf("Cannot parse: %v", e)
+        }
+        if e = X.Inject(JfifData{"Orientation": Word(1)}); e != nil {
+            t.Fatalf("Inject(): %v", e)
+        }
+        if e = X.Transform(tr); e != nil {
+            t.Fatalf("%v: %v", tr, e)
+        }
+        var out bytes.Buffer
+        for _, entry := range X.Entries {
+            if e = entry.Write(&out); e != nil {
+                t.Fatalf("%v: Write(): %v", tr, e)
+            }
+        }
+        dst, e := jpeg.Decode(&out)
+        if e != nil {
+            t.Fatalf("%v: jpeg.Decode(): %v", tr, e)
+        }
+        w, h := 77, 45
+        if tr.flipsX() { w = 64; }
+        if tr.flipsY() { h = 32; }
+        if tr.transposes() { w, h = h, w; }
+        if b := dst.Bounds(); b != image.Rect(0, 0, w, h) {
+            t.Fatalf("%v: bounds %v, expected %dx%d", tr, b, w, h)
+        }
+        ref := image.NewRGBA(dst.Bounds())
+        for y := 0; y < h; y++ {
+            for x := 0; x < w; x++ {
+                sx, sy := transformedAt(tr, x, y, w, h)
+                ref.Set(x, y, src.At(sx, sy))
+            }
+        }
+        if d := imageDiff(dst, ref); d > 0.5 {
+            t.Errorf("%v: mean difference %.3f", tr, d)
+        }
+        if o, e := X.Orientation(); e != nil || o != TransformNone {
+            t.Errorf("%v: orientation %v (%v)", tr, o, e)
+        }
+    }
+}
+
+func TestCrop(t *testing.T) {
+    data := testJpeg(t, 77, 45)
+    src, _ := jpeg.Decode(bytes.NewReader(data))
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    exif := NewExif()
+    exif.SetValue("ifd1.XResolution", Rational{72, 1})
+    exif.Thumbnail = []byte{0xff, 0xd8, 0xff, 0xd9}
+    if e := X.SetExif(exif); e != nil {
+        t.Fatalf("SetExif(): %v", e)
+    }
+    if e := X.Crop(20, 17, 40, 100); e != nil { // -> 44x29+16+16
+        t.Fatalf("Crop(): %v", e)
+    }
+    var out bytes.Buffer
+    for _, entry := range X.Entries {
+        if e := entry.Write(&out); e != nil {
+            t.Fatalf("Write(): %v", e)
+        }
+    }
+    dst, e := jpeg.Decode(&out)
+    if e != nil {
+        t.Fatalf("jpeg.Decode(): %v", e)
+    }
+    if b := dst.Bounds(); b != image.Rect(0, 0, 44, 29) {
+        t.Fatalf("Got bounds %v", b)
+    }
+    ref := image.NewRGBA(dst.Bounds())
+    for y := 0; y < 29; y++ {
+        for x := 0; x < 44; x++ { ref.Set(x, y, src.At(x + 16, y + 16)); }
+    }
+    if d := imageDiff(dst, ref); d > 0.5 {
+        t.Errorf("Mean difference %.3f", d)
+    }
+    if exif, _ = X.Exif(); exif.Thumbnail != nil || exif.Ifd[IFD1] != nil {
+        t.Errorf("Thumbnail left: %v", exif.Values())
+    }
+    if e := X.Crop(77, 0, 1, 1); e == nil {
+        t.Errorf("Crop() out of the image succeeded")
+    }
+}
+
+func TestOptimalTables(t *testing.T) {
+    data := testJpeg(t, 40, 24)
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    img, e := X.decodeCoefficients()
+    if e != nil {
+        t.Fatalf("decodeCoefficients(): %v", e)
+    }
+    for _, entry := range X.Entries { // tables lacking almost every symbol
+        if dht, ok := entry.(*DhtEntry); ok {
+            for i := range dht.Tables {
+                dht.Tables[i].Counts = [16]byte{1}
+                dht.Tables[i].Symbols = []byte{0}
+            }
+        }
+    }
+    if e = X.encodeScans(img); e != nil {
+        t.Fatalf("encodeScans(): %v", e)
+    }
+    var out bytes.Buffer
+    for _, entry := range X.Entries {
+        if e = entry.Write(&out); e != nil {
+            t.Fatalf("Write(): %v", e)
+        }
+    }
+    ref, _ := jpeg.Decode(bytes.NewReader(data))
+    dst, e := jpeg.Decode(&out)
+    if e != nil {
+        t.Fatalf("jpeg.Decode(): %v", e)
+    }
+    if d := imageDiff(dst, ref); d != 0 {
+        t.Errorf("Mean difference %.3f", d)
+    }
+}
+
+func TestOrientation(t *testing.T) {
+    // rotating an image to be displayed rotated 90 by 180 makes it
+    // to be displayed rotated 270
+    if o := Rotate180.compose(Rotate90).compose(Rotate180.inverse()); o != Rotate90 {
+        t.Errorf("Got %v", o)
+    }
+    if o := Rotate90.compose(FlipHorizontal).compose(Rotate90.inverse()); o != FlipVertical {
+        t.Errorf("Got %v", o)
+    }
+    data := testJpeg(t, 48, 32)
+    var X Jfif
+    if e := X.Parse(data); e != nil {
+        t.Fatalf("Cannot parse: %v", e)
+    }
+    if e := X.Inject(JfifData{"Orientation": Word(Rotate90)}); e != nil {
+        t.Fatalf("Inject(): %v", e)
+    }
+    if e := X.Normalize(); e != nil {
+        t.Fatalf("Normalize(): %v", e)
+    }
+    if o, _ := X.Orientation(); o != TransformNone {
+        t.Errorf("Orientation %v after Normalize()", o)
+    }
+    if sof := X.Frame(); sof.Width != 32 || sof.Height != 48 {
+        t.Errorf("Got %dx%d after Normalize()", sof.Width, sof.Height)
+    }
+}
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
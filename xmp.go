@@ -0,0 +1,542 @@
+package jfif
+
+import (
+    "io"
+    "fmt"
+    "sort"
+    "bytes"
+    "strings"
+    "encoding/xml"
+)
+
+const XmpHeader = SigXMP + "\x00"
+
+const (
+    nsRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+    nsXML = "http://www.w3.org/XML/1998/namespace"
+    nsMeta = "adobe:ns:meta/"
+)
+
+// the usual prefixes, used when the packet does not declare its own
+var XmpNamespaces = map[string]string{
+    "dc": "http://purl.org/dc/elements/1.1/",
+    "xmp": "http://ns.adobe.com/xap/1.0/",
+    "xmpRights": "http://ns.adobe.com/xap/1.0/rights/",
+    "xmpMM": "http://ns.adobe.com/xap/1.0/mm/",
+    "xmpNote": "http://ns.adobe.com/xmp/note/",
+    "photoshop": "http://ns.adobe.com/photoshop/1.0/",
+    "tiff": "http://ns.adobe.com/tiff/1.0/",
+    "exif": "http://ns.adobe.com/exif/1.0/",
+    "Iptc4xmpCore": "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/",
+    "lr": "http://ns.adobe.com/lightroom/1.0/",
+}
+
+// array kind ("Bag", "Seq" or "Alt") of the standard array properties by
+// namespace URI and name, whatever prefix the packet binds the URI to
+var XmpArrays = map[xml.Name]string{
+    {Space: XmpNamespaces["dc"], Local: "contributor"}: "Bag",
+    {Space: XmpNamespaces["dc"], Local: "creator"}: "Seq",
+    {Space: XmpNamespaces["dc"], Local: "date"}: "Seq",
+    {Space: XmpNamespaces["dc"], Local: "description"}: "Alt",
+    {Space: XmpNamespaces["dc"], Local: "language"}: "Bag",
+    {Space: XmpNamespaces["dc"], Local: "publisher"}: "Bag",
+    {Space: XmpNamespaces["dc"], Local: "relation"}: "Bag",
+    {Space: XmpNamespaces["dc"], Local: "rights"}: "Alt",
+    {Space: XmpNamespaces["dc"], Local: "subject"}: "Bag",
+    {Space: XmpNamespaces["dc"], Local: "title"}: "Alt",
+    {Space: XmpNamespaces["dc"], Local: "type"}: "Bag",
+    {Space: XmpNamespaces["xmp"], Local: "Identifier"}: "Bag",
+    {Space: XmpNamespaces["xmpRights"], Local: "Owner"}: "Bag",
+    {Space: XmpNamespaces["xmpRights"], Local: "UsageTerms"}: "Alt",
+    {Space: XmpNamespaces["photoshop"], Local: "SupplementalCategories"}: "Bag",
+    {Space: XmpNamespaces["lr"], Local: "hierarchicalSubject"}: "Bag",
+}
+
+// an XMP property: a simple value, a struct (Fields) or an array (Items)
+type XmpProperty struct {
+    Name xml.Name // Space is the namespace URI
+    Value string
+    Lang string // xml:lang of alternative items
+    Fields []*XmpProperty
+    Array string // "Bag", "Seq" or "Alt" for arrays
+    Items []*XmpProperty
+}
+
+// the value of a simple property or the values of the array items
+func (p *XmpProperty) Strings() []string {
+    if p.Array == "" {
+        return []string{p.Value}
+    }
+    var res []string
+    for _, item := range p.Items { res = append(res, item.Value); }
+    return res
+}
+
+type Xmp struct {
+    About string
+    Namespaces map[string]string // prefix -> URI as declared in the packet
+    Properties []*XmpProperty
+    Padding int // whitespace before the packet trailer
+
+    size int // of the packet parsed, kept on write if possible
+}
+
+func NewXMP() *Xmp {
+    return &Xmp{Namespaces: map[string]string{}, Padding: 2048}
+}
+
+func IsXMP(data []byte) bool {
+    return bytes.HasPrefix(data, []byte(XmpHeader))
+}
+
+// parses XMP packet (without the APP1 signature)
+func ParseXMP(packet []byte) (*Xmp, error) {
+    x := NewXMP()
+    x.size = len(packet)
+    x.Padding = 0
+    if end := bytes.LastIndex(packet, []byte("<?xpacket end")); end >= 0 {
+        body := bytes.TrimRight(packet[:end], " \t\r\n")
+        x.Padding = end - len(body)
+    }
+    d := xml.NewDecoder(bytes.NewReader(packet))
+    for {
+        tok, e := d.Token()
+        if e == io.EOF {
+            return x, nil
+        }
+        if e != nil {
+            return nil, fmt.Errorf("exif.ParseXMP: %w", e)
+        }
+        if se, ok := tok.(xml.StartElement); ok {
+            x.declare(se)
+            if se.Name.Space == nsRDF && se.Name.Local == "Description" {
+                props, e := x.parseDescription(d, se)
+                if e != nil {
+                    return nil, fmt.Errorf("exif.ParseXMP: %w", e)
+                }
+                x.Properties = append(x.Properties, props...)
+            }
+        }
+    }
+}
+
+// records namespace declarations of the element
+func (x *Xmp) declare(se xml.StartElement) {
+    for _, a := range se.Attr {
+        if a.Name.Space == "xmlns" {
+            x.Namespaces[a.Name.Local] = a.Value
+        }
+    }
+}
+
+func (x *Xmp) parseDescription(d *xml.Decoder, start xml.StartElement) ([]*XmpProperty, error) {
+    var props []*XmpProperty
+    for _, a := range start.Attr {
+        if a.Name.Space == nsRDF && a.Name.Local == "about" { x.About = a.Value; }
+        if a.Name.Space == "xmlns" || a.Name.Space == nsRDF || a.Name.Space == nsXML ||
+           a.Name.Space == "" {
+            continue
+        }
+        props = append(props, &XmpProperty{Name: a.Name, Value: a.Value})
+    }
+    for {
+        tok, e := d.Token()
+        if e != nil {
+            return nil, e
+        }
+        switch t := tok.(type) {
+        case xml.StartElement:
+            x.declare(t)
+            p, e := x.parseProperty(d, t)
+            if e != nil {
+                return nil, e
+            }
+            props = append(props, p)
+        case xml.EndElement:
+            return props, nil
+        }
+    }
+}
+
+func (x *Xmp) parseProperty(d *xml.Decoder, start xml.StartElement) (*XmpProperty, error) {
+    p := &XmpProperty{Name: start.Name}
+    resource, complex := false, false
+    for _, a := range start.Attr {
+        switch {
+        case a.Name.Space == nsXML && a.Name.Local == "lang": p.Lang = a.Value
+        case a.Name.Space == nsRDF && a.Name.Local == "parseType": resource = a.Value == "Resource"
+        case a.Name.Space == nsRDF && a.Name.Local == "resource": p.Value = a.Value
+        case a.Name.Space == "xmlns" || a.Name.Space == nsRDF || a.Name.Space == "":
+        default: // qualifiers or struct fields in attribute form
+            p.Fields = append(p.Fields, &XmpProperty{Name: a.Name, Value: a.Value})
+        }
+    }
+    var text strings.Builder
+    for {
+        tok, e := d.Token()
+        if e != nil {
+            return nil, e
+        }
+        switch t := tok.(type) {
+        case xml.CharData:
+            text.Write(t)
+        case xml.StartElement:
+            x.declare(t)
+            complex = true
+            switch {
+            case !resource && t.Name.Space == nsRDF &&
+                 (t.Name.Local == "Bag" || t.Name.Local == "Seq" || t.Name.Local == "Alt"):
+                p.Array = t.Name.Local
+                if p.Items, e = x.parseItems(d); e != nil {
+                    return nil, e
+                }
+            case !resource && t.Name.Space == nsRDF && t.Name.Local == "Description":
+                fields, e := x.parseDescription(d, t)
+                if e != nil {
+                    return nil, e
+                }
+                p.Fields = append(p.Fields, fields...)
+            default:
+                f, e := x.parseProperty(d, t)
+                if e != nil {
+                    return nil, e
+                }
+                p.Fields = append(p.Fields, f)
+            }
+        case xml.EndElement:
+            if !complex && !resource && p.Value == "" {
+                p.Value = text.String()
+            }
+            return p, nil
+        }
+    }
+}
+
+func (x *Xmp) parseItems(d *xml.Decoder) ([]*XmpProperty, error) {
+    var items []*XmpProperty
+    for {
+        tok, e := d.Token()
+        if e != nil {
+            return nil, e
+        }
+        switch t := tok.(type) {
+        case xml.StartElement:
+            x.declare(t)
+            item, e := x.parseProperty(d, t)
+            if e != nil {
+                return nil, e
+            }
+            items = append(items, item)
+        case xml.EndElement:
+            return items, nil
+        }
+    }
+}
+
+// resolves "prefix:name" with the packet or the usual namespaces
+func (x *Xmp) resolve(name string) (xml.Name, error) {
+    colon := strings.IndexByte(name, ':')
+    if colon < 0 {
+        return xml.Name{}, fmt.Errorf("no namespace prefix in %q", name)
+    }
+    prefix := name[:colon]
+    uri, ok := x.Namespaces[prefix]
+    if !ok { uri, ok = XmpNamespaces[prefix]; }
+    if !ok {
+        return xml.Name{}, fmt.Errorf("unknown namespace prefix in %q", name)
+    }
+    return xml.Name{Space: uri, Local: name[colon+1:]}, nil
+}
+
+// the prefix to write `uri` with; of the packet prefixes bound to the same
+// URI the first in sort order is taken so the output does not vary
+func (x *Xmp) prefix(uri string) string {
+    res := ""
+    for p, u := range x.Namespaces {
+        if u == uri && p != "" && (res == "" || p < res) { res = p; }
+    }
+    if res != "" {
+        return res
+    }
+    for p, u := range XmpNamespaces {
+        if u == uri { return p; }
+    }
+    return ""
+}
+
+// the property named "prefix:name" ("dc:title"), nil if there is none
+func (x *Xmp) Get(name string) *XmpProperty {
+    n, e := x.resolve(name)
+    if e != nil {
+        return nil
+    }
+    for _, p := range x.Properties {
+        if p.Name == n { return p; }
+    }
+    return nil
+}
+
+// drops the property named "prefix:name"
+func (x *Xmp) Remove(name string) bool {
+    n, e := x.resolve(name)
+    if e != nil {
+        return false
+    }
+    for i, p := range x.Properties {
+        if p.Name == n {
+            x.Properties = append(x.Properties[:i], x.Properties[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+// sets the property named "prefix:name" to `value`: a string or a number
+// for a simple property (or a single item of a known array, see XmpArrays),
+// []string for an array, *XmpProperty for anything else
+func (x *Xmp) SetValue(name string, value interface{}) error {
+    n, e := x.resolve(name)
+    if e != nil {
+        return fmt.Errorf("exif.Xmp.SetValue(%q): %w", name, e)
+    }
+    kind := XmpArrays[n]
+    p := &XmpProperty{Name: n}
+    var items []string
+    switch v := value.(type) {
+    case string: items = []string{v}
+    case String: items = []string{string(v)}
+    case int, int64, Byte, Word, Long, SByte, SWord, SLong:
+        items = []string{fmt.Sprint(v)}
+    case Rational: items = []string{fmt.Sprintf("%d/%d", v.Num, v.Den)}
+    case []string:
+        items = v
+        if kind == "" { kind = "Bag"; }
+    case *XmpProperty:
+        *p = *v
+        p.Name = n
+    default:
+        return fmt.Errorf("exif.Xmp.SetValue(%q): unsupported value %T", name, value)
+    }
+    if _, ok := value.(*XmpProperty); !ok {
+        if kind == "" {
+            p.Value = items[0]
+        } else {
+            p.Array = kind
+            for i, s := range items {
+                item := &XmpProperty{Name: xml.Name{Space: nsRDF, Local: "li"}, Value: s}
+                if kind == "Alt" && i == 0 { item.Lang = "x-default"; }
+                p.Items = append(p.Items, item)
+            }
+        }
+    }
+    for i := range x.Properties {
+        if x.Properties[i].Name == n {
+            x.Properties[i] = p
+            return nil
+        }
+    }
+    x.Properties = append(x.Properties, p)
+    return nil
+}
+
+// collects namespaces of the properties, assigning prefixes to unknown ones
+func (x *Xmp) usedNamespaces(props []*XmpProperty, uris map[string]string) {
+    for _, p := range props {
+        if p.Name.Space != nsRDF && p.Name.Space != "" && uris[p.Name.Space] == "" {
+            prefix := x.prefix(p.Name.Space)
+            if prefix == "" {
+                prefix = fmt.Sprintf("ns%d", len(uris) + 1)
+                x.Namespaces[prefix] = p.Name.Space
+            }
+            uris[p.Name.Space] = prefix
+        }
+        x.usedNamespaces(p.Fields, uris)
+        x.usedNamespaces(p.Items, uris)
+    }
+}
+
+func writeXmpText(b *bytes.Buffer, s string) {
+    xml.EscapeText(b, []byte(s))
+}
+
+func (x *Xmp) writeProperty(b *bytes.Buffer, p *XmpProperty, uris map[string]string, indent string) {
+    name := "rdf:" + p.Name.Local
+    if p.Name.Space != nsRDF { name = uris[p.Name.Space] + ":" + p.Name.Local; }
+    b.WriteString(indent + "<" + name)
+    if p.Lang != "" {
+        b.WriteString(` xml:lang="`)
+        writeXmpText(b, p.Lang)
+        b.WriteString(`"`)
+    }
+    switch {
+    case p.Array != "":
+        b.WriteString(">\n" + indent + " <rdf:" + p.Array + ">\n")
+        for _, item := range p.Items {
+            x.writeProperty(b, item, uris, indent + "  ")
+        }
+        b.WriteString(indent + " </rdf:" + p.Array + ">\n" + indent)
+    case p.Fields != nil:
+        b.WriteString(` rdf:parseType="Resource">` + "\n")
+        for _, f := range p.Fields {
+            x.writeProperty(b, f, uris, indent + " ")
+        }
+        b.WriteString(indent)
+    default:
+        b.WriteString(">")
+        writeXmpText(b, p.Value)
+    }
+    b.WriteString("</" + name + ">\n")
+}
+
+const (
+    xmpBegin = "<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n"
+    xmpEnd = `<?xpacket end="w"?>`
+)
+
+// the x:xmpmeta element with the `props`
+func (x *Xmp) meta(props []*XmpProperty) []byte {
+    if x.Namespaces == nil { x.Namespaces = map[string]string{}; }
+    uris := make(map[string]string)
+    x.usedNamespaces(props, uris)
+    var b bytes.Buffer
+    b.WriteString(`<x:xmpmeta xmlns:x="` + nsMeta + `">` + "\n")
+    b.WriteString(` <rdf:RDF xmlns:rdf="` + nsRDF + `">` + "\n")
+    b.WriteString(`  <rdf:Description rdf:about="`)
+    writeXmpText(&b, x.About)
+    b.WriteString(`"`)
+    prefixes := make([]string, 0, len(uris))
+    for uri := range uris { prefixes = append(prefixes, uris[uri] + "\x00" + uri); }
+    sort.Strings(prefixes)
+    for _, pu := range prefixes {
+        i := strings.IndexByte(pu, 0)
+        b.WriteString("\n    xmlns:" + pu[:i] + `="`)
+        writeXmpText(&b, pu[i+1:])
+        b.WriteString(`"`)
+    }
+    b.WriteString(">\n")
+    for _, p := range props {
+        x.writeProperty(&b, p, uris, "   ")
+    }
+    b.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n")
+    return b.Bytes()
+}
+
+// the packet with the trailer; it keeps the size of the parsed one if
+// the properties fit in, otherwise the Padding is used
+func (x *Xmp) Bytes() ([]byte, error) {
+    var b bytes.Buffer
+    b.WriteString(xmpBegin)
+    b.Write(x.meta(x.Properties))
+    pad := x.Padding
+    if n := x.size - b.Len() - len(xmpEnd); x.size > 0 && n >= 0 {
+        pad = n
+    }
+    for i := 0; i < pad; i++ {
+        if i % 100 == 99 || i == pad - 1 { b.WriteByte('\n'); } else { b.WriteByte(' '); }
+    }
+    b.WriteString(xmpEnd)
+    return b.Bytes(), nil
+}
+
+// cuts the padding of the packet down so it fits in APP1 with the signature
+func fitXmp(packet []byte) ([]byte, bool) {
+    over := len(XmpHeader) + len(packet) - (65535 - 2)
+    if over <= 0 {
+        return packet, true
+    }
+    trailer := bytes.LastIndex(packet, []byte(xmpEnd))
+    if trailer < 0 {
+        return packet, false
+    }
+    body := len(bytes.TrimRight(packet[:trailer], " \n"))
+    if trailer - body < over {
+        return packet, false
+    }
+    return append(packet[:trailer - over], packet[trailer:]...), true
+}
+
+// finds the (main) XMP APP1 segment; with `create` a new one is inserted
+// right after the Exif one (or SOI/APP0) if there is none
+func (x *Jfif) xmpEntry(create bool) (*AppnEntry, error) {
+    for _, entry := range x.Entries {
+        if app, ok := entry.(*AppnEntry); ok && app.ID == APP1 && IsXMP(app.Data) {
+            return app, nil
+        }
+    }
+    if !create {
+        return nil, nil
+    }
+    app := &AppnEntry{Xff0: 255, ID: APP1, Data: []byte(XmpHeader)}
+    at := 0
+    for at < len(x.Entries) {
+        entry := x.Entries[at]
+        if id := entry.GetId(); id != SOI && id != APP0 {
+            if exif, ok := entry.(*AppnEntry); !ok || id != APP1 || !IsExif(exif.Data) { break; }
+        }
+        at++
+    }
+    if e := x.insert(at, app); e != nil {
+        return nil, e
+    }
+    return app, nil
+}
+
+// parses the XMP APP1 segment, returns nil if there is none; the extended
+// XMP properties, if any, are merged in; if the extended XMP is missing or
+// broken the main packet properties come with an error wrapping
+// ErrExtendedXMP, so errors.Is tells it from a bad main packet
+func (x *Jfif) XMP() (*Xmp, error) {
+    app, _ := x.xmpEntry(false)
+    if app == nil {
+        return nil, nil
+    }
+    xmp, e := ParseXMP(app.Data[len(XmpHeader):])
+    if e != nil {
+        return nil, fmt.Errorf("exif.XMP(%q): %w", x.Path, e)
+    }
+    if e = x.mergeExtendedXMP(xmp); e != nil {
+        x.debug("extended XMP is ignored", "error", e)
+        return xmp, fmt.Errorf("exif.XMP(%q): %w", x.Path, e)
+    }
+    return xmp, nil
+}
+
+// stores the XMP packet into the APP1 segment, inserting one if needed; the
+// padding is cut down if the segment would not fit otherwise, and if it
+// still does not, the rest of the properties go to the extended XMP
+func (x *Jfif) SetXMP(xmp *Xmp) error {
+    main := *xmp
+    main.Namespaces = make(map[string]string, len(xmp.Namespaces))
+    for prefix, uri := range xmp.Namespaces { main.Namespaces[prefix] = uri; }
+    main.Properties = nil
+    for _, p := range xmp.Properties {
+        if p.Name != xmpHasExtended { main.Properties = append(main.Properties, p); }
+    }
+    packet, e := main.Bytes()
+    if e != nil {
+        return fmt.Errorf("exif.SetXMP(%q): %w", x.Path, e)
+    }
+    var ext []byte
+    var guid string
+    packet, ok := fitXmp(packet)
+    if !ok {
+        main.Properties, ext, guid = main.splitExtended()
+        if packet, e = main.Bytes(); e != nil {
+            return fmt.Errorf("exif.SetXMP(%q): %w", x.Path, e)
+        }
+        if packet, ok = fitXmp(packet); !ok {
+            return fmt.Errorf("exif.SetXMP(%q): packet of %d bytes does not fit in APP1", x.Path, len(packet))
+        }
+    }
+    app, e := x.xmpEntry(true)
+    if e != nil {
+        return fmt.Errorf("exif.SetXMP(%q): %w", x.Path, e)
+    }
+    app.Data = append([]byte(XmpHeader), packet...)
+    if e = x.setExtendedXMP(app, guid, ext); e != nil {
+        return fmt.Errorf("exif.SetXMP(%q): %w", x.Path, e)
+    }
+    return nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
@@ -0,0 +1,228 @@
+package jfif
+
+import (
+    "fmt"
+    "errors"
+    "strings"
+    "encoding/xml"
+)
+import "testing"
+
+var testPacket = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about="" xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmp:Rating="3">
+   <dc:title xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <rdf:Alt><rdf:li xml:lang="x-default">Old &amp; title</rdf:li></rdf:Alt>
+   </dc:title>
+  </rdf:Description>
+  <rdf:Description rdf:about="" xmlns:my="http://example.com/my/">
+   <my:Place rdf:parseType="Resource">
+    <my:City>Moscow</my:City>
+   </my:Place>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+` + strings.Repeat(" ", 500) + `
+<?xpacket end="w"?>`
+
+func TestXMP(t *testing.T) {
+    xmp, e := ParseXMP([]byte(testPacket))
+    if e != nil {
+        t.Fatalf("ParseXMP(): %v", e)
+    }
+    if p := xmp.Get("xmp:Rating"); p == nil || p.Value != "3" {
+        t.Errorf("xmp:Rating: %+v", p)
+    }
+    if p := xmp.Get("dc:title"); p == nil || p.Array != "Alt" || len(p.Items) != 1 ||
+       p.Items[0].Lang != "x-default" || p.Items[0].Value != "Old & title" {
+        t.Errorf("dc:title: %+v", p)
+    }
+    if p := xmp.Get("my:Place"); p == nil || len(p.Fields) != 1 || p.Fields[0].Value != "Moscow" {
+        t.Errorf("my:Place: %+v", p)
+    }
+
+    if e = xmp.SetValue("dc:title", "New title"); e != nil {
+        t.Fatalf("SetValue(dc:title): %v", e)
+    }
+    if e = xmp.SetValue("xmp:Rating", 5); e != nil {
+        t.Fatalf("SetValue(xmp:Rating): %v", e)
+    }
+    if e = xmp.SetValue("dc:subject", []string{"cat", "dog"}); e != nil {
+        t.Fatalf("SetValue(dc:subject): %v", e)
+    }
+    if e = xmp.SetValue("zz:bad", "x"); e == nil {
+        t.Errorf("SetValue(zz:bad) succeeded")
+    }
+
+    var X Jfif
+    if e = X.Load(testImages(t)[0]); e != nil {
+        t.Fatalf("Cannot load: %v", e)
+    }
+    if e = X.SetXMP(xmp); e != nil {
+        t.Fatalf("SetXMP(): %v", e)
+    }
+    data, e := X.Bytes()
+    if e != nil {
+        t.Fatalf("Bytes(): %v", e)
+    }
+    var Y Jfif
+    if e = Y.Parse(data); e != nil {
+        t.Fatalf("Parse(): %v", e)
+    }
+    app, _ := Y.xmpEntry(false)
+    if app == nil {
+        t.Fatalf("No XMP segment")
+    }
+    if n := len(app.Data) - len(XmpHeader); n != len(testPacket) {
+        t.Errorf("Packet of %d bytes, expected %d", n, len(testPacket))
+    }
+    if !strings.HasSuffix(string(app.Data), "   \n" + `<?xpacket end="w"?>`) {
+        t.Errorf("No padding in %q", app.Data)
+    }
+    got, e := Y.XMP()
+    if e != nil {
+        t.Fatalf("XMP(): %v", e)
+    }
+    if p := got.Get("dc:title"); p == nil || strings.Join(p.Strings(), ",") != "New title" {
+        t.Errorf("dc:title: %+v", p)
+    }
+    if p := got.Get("xmp:Rating"); p == nil || p.Value != "5" {
+        t.Errorf("xmp:Rating: %+v", p)
+    }
+    if p := got.Get("dc:subject"); p == nil || p.Array != "Bag" || strings.Join(p.Strings(), ",") != "cat,dog" {
+        t.Errorf("dc:subject: %+v", p)
+    }
+    if p := got.Get("my:Place"); p == nil || len(p.Fields) != 1 || p.Fields[0].Value != "Moscow" {
+        t.Errorf("my:Place: %+v", p)
+    }
+
+    // the array kind does not depend on the prefix the packet uses
+    own := NewXMP()
+    own.Namespaces["purl"] = XmpNamespaces["dc"]
+    if e = own.SetValue("purl:title", "Title"); e != nil {
+        t.Fatalf("SetValue(purl:title): %v", e)
+    }
+    if p := own.Get("dc:title"); p == nil || p.Array != "Alt" || len(p.Items) != 1 || p.Items[0].Lang != "x-default" {
+        t.Errorf("purl:title: %+v", p)
+    }
+
+    // the prefixes SetXMP assigns stay out of the caller's Xmp
+    own = NewXMP()
+    own.Properties = append(own.Properties, &XmpProperty{Name: xml.Name{Space: "http://example.com/x/", Local: "v"}, Value: "1"})
+    if e = X.SetXMP(own); e != nil {
+        t.Fatalf("SetXMP(): %v", e)
+    }
+    if len(own.Namespaces) != 0 {
+        t.Errorf("SetXMP() changed namespaces %v", own.Namespaces)
+    }
+    own.Namespaces["b"] = "http://example.com/x/"
+    own.Namespaces["a"] = "http://example.com/x/"
+    for i := 0; i < 10; i++ {
+        if p := own.prefix("http://example.com/x/"); p != "a" {
+            t.Fatalf("Prefix %q, expected \"a\"", p)
+        }
+    }
+}
+
+func TestExtendedXMP(t *testing.T) {
+    xmp := NewXMP()
+    xmp.Namespaces["my"] = "http://example.com/my/"
+    for i := 0; i < 20; i++ {
+        if e := xmp.SetValue(fmt.Sprintf("my:p%d", i), strings.Repeat(fmt.Sprint(i % 10), 10000)); e != nil {
+            t.Fatalf("SetValue(): %v", e)
+        }
+    }
+    if e := xmp.SetValue("xmp:Rating", 4); e != nil {
+        t.Fatalf("SetValue(): %v", e)
+    }
+    var X Jfif
+    if e := X.Load(testImages(t)[0]); e != nil {
+        t.Fatalf("Cannot load: %v", e)
+    }
+    if e := X.SetXMP(xmp); e != nil {
+        t.Fatalf("SetXMP(): %v", e)
+    }
+    data, e := X.Bytes()
+    if e != nil {
+        t.Fatalf("Bytes(): %v", e)
+    }
+    var Y Jfif
+    if e = Y.Parse(data); e != nil {
+        t.Fatalf("Parse(): %v", e)
+    }
+    var chunks []*AppnEntry
+    for _, entry := range Y.FindAll(APP1) {
+        if app := entry.(*AppnEntry); IsExtendedXMP(app.Data) { chunks = append(chunks, app); }
+    }
+    if len(chunks) < 2 {
+        t.Fatalf("Got %d extended XMP chunks", len(chunks))
+    }
+    got, e := Y.XMP()
+    if e != nil {
+        t.Fatalf("XMP(): %v", e)
+    }
+    if len(got.Properties) != 21 || got.Get("xmpNote:HasExtendedXMP") != nil {
+        t.Errorf("Got %d properties", len(got.Properties))
+    }
+    if p := got.Get("my:p13"); p == nil || p.Value != strings.Repeat("3", 10000) {
+        t.Errorf("my:p13 is lost")
+    }
+    if p := got.Get("xmp:Rating"); p == nil || p.Value != "4" {
+        t.Errorf("xmp:Rating: %+v", p)
+    }
+
+    // fits in again: the extension goes away
+    for i := 0; i < 20; i++ { got.Remove(fmt.Sprintf("my:p%d", i)); }
+    if e = Y.SetXMP(got); e != nil {
+        t.Fatalf("SetXMP(): %v", e)
+    }
+    if n := len(Y.FindAll(APP1)); n != 1 {
+        t.Errorf("Got %d APP1 segments, expected 1", n)
+    }
+
+    var Z Jfif
+    if e = Z.Parse(data); e != nil {
+        t.Fatalf("Parse(): %v", e)
+    }
+    var last *AppnEntry
+    for _, entry := range Z.FindAll(APP1) {
+        if app := entry.(*AppnEntry); IsExtendedXMP(app.Data) { last = app; }
+    }
+    last.Data[len(last.Data) - 100] ^= 1
+    broken, e := Z.XMP()
+    if !errors.Is(e, ErrExtendedXMP) || !strings.Contains(e.Error(), "MD5") {
+        t.Errorf("Corrupted chunk: %v", e)
+    }
+    if broken == nil || broken.Get("xmp:Rating") == nil || broken.Get("xmpNote:HasExtendedXMP") != nil {
+        t.Fatalf("Main packet is lost with the corrupted chunk: %+v", broken)
+    }
+    if n := len(broken.Properties); n == 0 || n >= 21 {
+        t.Errorf("Got %d properties of the main packet", n)
+    }
+    if e = Z.Remove(last); e != nil {
+        t.Fatalf("Remove(): %v", e)
+    }
+    if broken, e = Z.XMP(); !errors.Is(e, ErrExtendedXMP) || broken == nil {
+        t.Errorf("Missing chunk: %v", e)
+    }
+
+    // the full length claimed by the chunks is not allocated blindly
+    var W Jfif
+    if e = W.Parse(data); e != nil {
+        t.Fatalf("Parse(): %v", e)
+    }
+    var guid string
+    for _, entry := range W.FindAll(APP1) {
+        if app := entry.(*AppnEntry); IsExtendedXMP(app.Data) {
+            guid = string(app.Data[len(XmpExtHeader):][:32])
+            app.Data[len(XmpExtHeader) + 32] = 0xff
+        }
+    }
+    if _, e = W.ExtendedXMP(guid); e == nil || !strings.Contains(e.Error(), "bytes") {
+        t.Errorf("Huge extended XMP: %v", e)
+    }
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */
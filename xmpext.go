@@ -0,0 +1,154 @@
+package jfif
+
+import (
+    "fmt"
+    "sort"
+    "errors"
+    "bytes"
+    "crypto/md5"
+    "encoding/xml"
+    "encoding/binary"
+)
+
+const XmpExtHeader = SigXMPExt + "\x00"
+
+// GUID (32) + full length (4) + offset (4)
+const xmpExtChunkHeader = 32 + 4 + 4
+
+// chunk data per extended XMP segment
+const xmpExtChunkSize = 65535 - 2 - len(XmpExtHeader) - xmpExtChunkHeader
+
+// the extended XMP the main packet refers to is missing or broken
+var ErrExtendedXMP = errors.New("bad extended XMP")
+
+// the main packet property naming the extended one by its GUID
+var xmpHasExtended = xml.Name{Space: XmpNamespaces["xmpNote"], Local: "HasExtendedXMP"}
+
+func IsExtendedXMP(data []byte) bool {
+    return bytes.HasPrefix(data, []byte(XmpExtHeader)) &&
+           len(data) >= len(XmpExtHeader) + xmpExtChunkHeader
+}
+
+// the GUID of the extended XMP: MD5 of the packet in upper case hex
+func xmpGUID(packet []byte) string {
+    return fmt.Sprintf("%X", md5.Sum(packet))
+}
+
+// reassembles the extended XMP packet with the `guid` from its chunks;
+// the chunks must cover the packet and its MD5 must match the GUID
+func (x *Jfif) ExtendedXMP(guid string) ([]byte, error) {
+    type chunk struct { offset int; data []byte }
+    var chunks []chunk
+    size := -1
+    for _, entry := range x.Entries {
+        app, ok := entry.(*AppnEntry)
+        if !ok || app.ID != APP1 || !IsExtendedXMP(app.Data) { continue; }
+        d := app.Data[len(XmpExtHeader):]
+        if string(d[:32]) != guid { continue; }
+        full := int(binary.BigEndian.Uint32(d[32:]))
+        if size >= 0 && full != size {
+            return nil, fmt.Errorf("exif.ExtendedXMP(%q): chunks of %d and %d bytes packets", x.Path, size, full)
+        }
+        size = full
+        chunks = append(chunks, chunk{int(binary.BigEndian.Uint32(d[36:])), d[40:]})
+    }
+    if size < 0 {
+        return nil, fmt.Errorf("exif.ExtendedXMP(%q): no %s chunks", x.Path, guid)
+    }
+    total := 0
+    for _, c := range chunks { total += len(c.data); }
+    if total != size {
+        return nil, fmt.Errorf("exif.ExtendedXMP(%q): got %d of %d bytes", x.Path, total, size)
+    }
+    sort.SliceStable(chunks, func(i, j int) bool { return chunks[i].offset < chunks[j].offset; })
+    packet := make([]byte, 0, size)
+    for _, c := range chunks {
+        if c.offset != len(packet) || c.offset + len(c.data) > size {
+            return nil, fmt.Errorf("exif.ExtendedXMP(%q): chunk at %d, expected %d", x.Path, c.offset, len(packet))
+        }
+        packet = append(packet, c.data...)
+    }
+    if sum := xmpGUID(packet); sum != guid {
+        return nil, fmt.Errorf("exif.ExtendedXMP(%q): MD5 %s does not match GUID %s", x.Path, sum, guid)
+    }
+    return packet, nil
+}
+
+// adds the extended XMP properties the `xmp` refers to; the error wraps
+// ErrExtendedXMP and leaves the `xmp` without them
+func (x *Jfif) mergeExtendedXMP(xmp *Xmp) error {
+    var guid string
+    for i, p := range xmp.Properties {
+        if p.Name == xmpHasExtended {
+            guid = p.Value
+            xmp.Properties = append(xmp.Properties[:i], xmp.Properties[i+1:]...)
+            break
+        }
+    }
+    if guid == "" {
+        return nil
+    }
+    packet, e := x.ExtendedXMP(guid)
+    if e != nil {
+        return fmt.Errorf("%w: %w", ErrExtendedXMP, e)
+    }
+    ext, e := ParseXMP(packet)
+    if e != nil {
+        return fmt.Errorf("%w: %w", ErrExtendedXMP, e)
+    }
+    for prefix, uri := range ext.Namespaces {
+        if _, ok := xmp.Namespaces[prefix]; !ok { xmp.Namespaces[prefix] = uri; }
+    }
+    xmp.Properties = append(xmp.Properties, ext.Properties...)
+    return nil
+}
+
+// keeps as many properties in the main packet as fit in, the rest goes
+// to the extended packet; returns the main properties, the extended
+// packet and its GUID
+func (x *Xmp) splitExtended() ([]*XmpProperty, []byte, string) {
+    note := &XmpProperty{Name: xmpHasExtended, Value: xmpGUID(nil)}
+    limit := 65535 - 2 - len(XmpHeader) - len(xmpBegin) - len(xmpEnd)
+    main := []*XmpProperty{note}
+    var rest []*XmpProperty
+    for _, p := range x.Properties {
+        if len(x.meta(append(main, p))) <= limit {
+            main = append(main, p)
+        } else {
+            rest = append(rest, p)
+        }
+    }
+    packet := x.meta(rest)
+    note.Value = xmpGUID(packet)
+    return main, packet, note.Value
+}
+
+// replaces the extended XMP segments with the `packet` chunks put right
+// after the main XMP segment `app`
+func (x *Jfif) setExtendedXMP(app *AppnEntry, guid string, packet []byte) error {
+    var entries []Entry
+    for _, entry := range x.Entries {
+        if ext, ok := entry.(*AppnEntry); ok && ext.ID == APP1 && IsExtendedXMP(ext.Data) { continue; }
+        entries = append(entries, entry)
+    }
+    if e := x.setEntries(entries); e != nil {
+        return e
+    }
+    at := x.Index(app) + 1
+    for offset := 0; offset < len(packet); offset += xmpExtChunkSize {
+        end := offset + xmpExtChunkSize
+        if end > len(packet) { end = len(packet); }
+        data := make([]byte, 0, len(XmpExtHeader) + xmpExtChunkHeader + end - offset)
+        data = append(data, XmpExtHeader + guid...)
+        data = binary.BigEndian.AppendUint32(data, uint32(len(packet)))
+        data = binary.BigEndian.AppendUint32(data, uint32(offset))
+        data = append(data, packet[offset:end]...)
+        if e := x.insert(at, &AppnEntry{Xff0: 255, ID: APP1, Data: data}); e != nil {
+            return e
+        }
+        at++
+    }
+    return nil
+}
+
+/* vim: set ft=go ai et ts=4 sts=4 sw=4: EOF */